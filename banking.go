@@ -1,18 +1,24 @@
 package banking
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 
 	"github.com/binzume/gobanking/common"
-	"github.com/binzume/gobanking/mizuho"
-	"github.com/binzume/gobanking/rakuten"
-	"github.com/binzume/gobanking/sbi"
-	"github.com/binzume/gobanking/shinsei"
-	"github.com/binzume/gobanking/stub"
+	"github.com/binzume/gobanking/utils"
+
+	// bundled drivers
+	_ "github.com/binzume/gobanking/mizuho"
+	_ "github.com/binzume/gobanking/rakuten"
+	_ "github.com/binzume/gobanking/sbi"
+	_ "github.com/binzume/gobanking/shinsei"
+	_ "github.com/binzume/gobanking/stub"
 )
 
+type Driver = common.Driver
+
 type AccountConfig struct {
 	Bank     string                 `json:"bank"`
 	Id       string                 `json:"id"`
@@ -20,7 +26,7 @@ type AccountConfig struct {
 	Options  map[string]interface{} `json:"options"`
 }
 
-func LoginWithJsonFile(path string) (common.Account, error) {
+func LoadAccountConfig(path string) (*AccountConfig, error) {
 	buf, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -31,22 +37,82 @@ func LoginWithJsonFile(path string) (common.Account, error) {
 	if err != nil {
 		return nil, err
 	}
-	return Login(&c)
-}
-
-func Login(c *AccountConfig) (common.Account, error) {
-	switch c.Bank {
-	case "mizuho":
-		return mizuho.Login(c.Id, c.Password, c.Options)
-	case "rakuten":
-		return rakuten.Login(c.Id, c.Password, c.Options)
-	case "shinsei":
-		return shinsei.Login(c.Id, c.Password, c.Options)
-	case "sbi":
-		return sbi.Login(c.Id, c.Password, c.Options)
-	case "stub":
-		return stub.Login(c.Id, c.Password, c.Options)
-	default:
+	return &c, nil
+}
+
+// LoginWithJsonFile is LoginWithJsonFileContext without context.
+// Parsing is lenient unless "strict" is specified in the options.
+func LoginWithJsonFile(path string) (common.LegacyAccount, error) {
+	c, err := LoadAccountConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return Login(c)
+}
+
+func LoginWithJsonFileContext(ctx context.Context, path string) (common.Account, error) {
+	c, err := LoadAccountConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoginContext(ctx, c)
+}
+
+// Login is LoginContext without context.
+// Parsing is lenient unless "strict" is specified in the options.
+func Login(c *AccountConfig) (common.LegacyAccount, error) {
+	lenient := *c
+	lenient.Options = utils.Lenient(c.Options)
+	return legacy(LoginContext(context.Background(), &lenient))
+}
+
+func LoginContext(ctx context.Context, c *AccountConfig) (common.Account, error) {
+	d, ok := common.LookupDriver(c.Bank)
+	if !ok {
 		return nil, errors.New("unknown:" + c.Bank)
 	}
+	var acc common.Account
+	err := utils.GuardedLogin(c.Bank, c.Id, func() (err error) {
+		acc, err = d.Login(ctx, c.Id, c.Password, c.Options)
+		return
+	})
+	return acc, err
+}
+
+// Register makes a bank driver available for Login by the name (AccountConfig.Bank).
+// Bundled drivers are registered by their packages.
+func Register(name string, driver Driver) {
+	common.Register(name, driver)
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func Drivers() []string {
+	return common.Drivers()
+}
+
+// Capabilities reports which optional features acc supports.
+func Capabilities(acc common.Account) common.Capabilities {
+	_, history := acc.(common.HistoryProvider)
+	_, transfer := acc.(common.Transferer)
+	_, payees := acc.(common.PayeeLister)
+	_, fx := acc.(common.FxTrader)
+	_, refresh := acc.(common.Refresher)
+	_, accounts := acc.(common.MultiAccount)
+	_, keepalive := acc.(common.KeepAliver)
+	return common.Capabilities{
+		History:   history,
+		Transfer:  transfer,
+		Payees:    payees,
+		Fx:        fx,
+		Refresh:   refresh,
+		Accounts:  accounts,
+		Keepalive: keepalive,
+	}
+}
+
+func legacy(acc common.Account, err error) (common.LegacyAccount, error) {
+	if acc == nil {
+		return nil, err
+	}
+	return &common.Legacy{Account: acc}, err
 }
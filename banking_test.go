@@ -1,6 +1,12 @@
 package banking
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -20,6 +26,20 @@ func TestAccount(t *testing.T) {
 	var _ common.Account = &sbi.Account{}
 	var _ common.Account = &shinsei.Account{}
 	var _ common.Account = &stub.Account{}
+	var _ common.HistoryProvider = &mizuho.Account{}
+	var _ common.HistoryProvider = &rakuten.Account{}
+	var _ common.HistoryProvider = &shinsei.Account{}
+	var _ common.HistoryProvider = &stub.Account{}
+	var _ common.Transferer = &mizuho.Account{}
+	var _ common.Transferer = &rakuten.Account{}
+	var _ common.Transferer = &shinsei.Account{}
+	var _ common.Transferer = &stub.Account{}
+	var _ common.FxTrader = &shinsei.Account{}
+	var _ common.LegacyAccount = &mizuho.LegacyAccount{}
+	var _ common.LegacyAccount = &rakuten.LegacyAccount{}
+	var _ common.LegacyAccount = &sbi.LegacyAccount{}
+	var _ common.LegacyAccount = &shinsei.LegacyAccount{}
+	var _ common.LegacyAccount = &stub.LegacyAccount{}
 
 	utils.Debug = true
 
@@ -65,3 +85,297 @@ func TestAccount(t *testing.T) {
 		t.Errorf("failed to logout: %v", err)
 	}
 }
+
+func TestLoginContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := LoginContext(ctx, &AccountConfig{Bank: "mizuho", Id: "test", Password: "testtest"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled: %v", err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientOptions(t *testing.T) {
+	errBlocked := errors.New("blocked")
+	var ua string
+	opts := &utils.ClientOptions{
+		UserAgent: "Mozilla/5.0 test",
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			ua = req.Header.Get("User-Agent")
+			return nil, errBlocked
+		}),
+	}
+	for _, bank := range []string{"mizuho", "rakuten", "shinsei", "sbi"} {
+		ua = ""
+		_, err := LoginContext(context.Background(), &AccountConfig{Bank: bank, Id: "test", Password: "testtest",
+			Options: map[string]interface{}{utils.ClientOptionsKey: opts}})
+		if !errors.Is(err, errBlocked) {
+			t.Errorf("%s: expected errBlocked: %v", bank, err)
+		}
+		if ua != opts.UserAgent {
+			t.Errorf("%s: unexpected User-Agent: %q", bank, ua)
+		}
+	}
+
+	parsed, err := utils.ParseClientOptions(map[string]interface{}{
+		utils.ClientOptionsKey: map[string]interface{}{"proxy": "http://localhost:8080", "timeout": "30s"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Proxy.Host != "localhost:8080" || parsed.Timeout != 30*time.Second {
+		t.Errorf("unexpected options: %+v", parsed)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	utils.SetRateLimit("ratelimit.test", utils.RateLimit{Interval: 50 * time.Millisecond, Burst: 1})
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: http.NoBody, Request: req}, nil
+	})
+	// limiters are shared by clients.
+	c1, _ := utils.NewHttpClientWithOptions(&utils.ClientOptions{Transport: transport})
+	c2, _ := utils.NewHttpClientWithOptions(&utils.ClientOptions{Transport: transport})
+
+	start := time.Now()
+	for _, c := range []*http.Client{c1, c2, c1} {
+		res, err := c.Get("http://www.ratelimit.test/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("too fast: %v", d)
+	}
+}
+
+func TestLoginError(t *testing.T) {
+	_, err := LoginContext(context.Background(), &AccountConfig{Bank: "stub", Id: "test"})
+	if !errors.Is(err, common.ErrLoginRejected) {
+		t.Errorf("expected ErrLoginRejected: %v", err)
+	}
+	var bankErr *common.BankError
+	if !errors.As(err, &bankErr) || bankErr.Bank != "stub" {
+		t.Errorf("expected BankError: %v", err)
+	}
+
+	// package Login returns the account with the error as before.
+	acc, err := stub.Login("test", "", nil)
+	if !errors.Is(err, common.ErrLoginRejected) || acc == nil || acc.OwnerName != "test" {
+		t.Errorf("unexpected result: %v %v", acc, err)
+	}
+	if _, err := acc.Recent(); err != nil {
+		t.Errorf("legacy method failed: %v", err)
+	}
+}
+
+func TestLoginBreaker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failures.json")
+	SetLoginBreaker(&LoginBreaker{Path: path, MaxFailures: 2})
+	defer SetLoginBreaker(nil)
+
+	bad := &AccountConfig{Bank: "stub", Id: "test"}
+	for i := 0; i < 2; i++ {
+		if _, err := LoginContext(context.Background(), bad); !errors.Is(err, common.ErrLoginRejected) {
+			t.Fatalf("expected ErrLoginRejected: %v", err)
+		}
+	}
+
+	// persisted
+	b := &LoginBreaker{Path: path, MaxFailures: 2}
+	SetLoginBreaker(b)
+	good := &AccountConfig{Bank: "stub", Id: "test", Password: "testtest"}
+	_, err := LoginContext(context.Background(), good)
+	var circuitErr *CircuitOpenError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &circuitErr) || circuitErr.Failures != 2 {
+		t.Fatalf("expected CircuitOpenError: %v", err)
+	}
+
+	if err := b.Reset("stub", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoginContext(context.Background(), good); err != nil {
+		t.Fatalf("login failed after reset: %v", err)
+	}
+
+	// shared with another breaker through the file
+	other := &LoginBreaker{Path: path, MaxFailures: 2}
+	other.Record("stub", "test", common.ErrLoginRejected)
+	other.Record("stub", "test", common.ErrLoginRejected)
+	if err := b.Allow("stub", "test"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Allow("stub", "test"); err != nil {
+		t.Errorf("should be allowed after removing the file: %v", err)
+	}
+
+	b.CoolDown = time.Millisecond
+	b.Record("stub", "test", common.ErrLoginRejected)
+	b.Record("stub", "test", common.ErrLoginRejected)
+	time.Sleep(2 * time.Millisecond)
+	if err := b.Allow("stub", "test"); err != nil {
+		t.Errorf("should be allowed after cool-down: %v", err)
+	}
+}
+
+// registered counts drivers registered by TestRegister. Names must be unique for -count=N.
+var registered int
+
+func TestRegister(t *testing.T) {
+	registered++
+	name := fmt.Sprintf("test_driver%d", registered)
+	Register(name, common.DriverFunc(func(ctx context.Context, id, password string, options map[string]interface{}) (common.Account, error) {
+		return stub.LoginContext(ctx, id, password, options)
+	}))
+
+	found := false
+	for _, n := range Drivers() {
+		found = found || n == name
+	}
+	if !found {
+		t.Errorf("%v not found in %v", name, Drivers())
+	}
+
+	acc, err := LoginContext(context.Background(), &AccountConfig{Bank: name, Id: "test", Password: "testtest"})
+	if err != nil {
+		t.Fatalf("login failed %v", err)
+	}
+	if acc.AccountInfo().BankCode != stub.BankCode {
+		t.Errorf("unexpected account: %v", acc.AccountInfo())
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	caps := Capabilities(&stub.Account{})
+	if !caps.History || !caps.Transfer || caps.Fx {
+		t.Errorf("unexpected capabilities for stub: %+v", caps)
+	}
+
+	caps = Capabilities(&sbi.Account{})
+	if caps.History || caps.Transfer || caps.Accounts {
+		t.Errorf("unexpected capabilities for sbi: %+v", caps)
+	}
+
+	legacy := &common.Legacy{Account: &sbi.Account{}}
+	if _, err := legacy.Recent(); !errors.Is(err, common.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported: %v", err)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	ctx := context.Background()
+	acc, err := stub.LoginContext(ctx, "test", "dummy", map[string]interface{}{"expired": true})
+	if err != nil {
+		t.Fatalf("login failed %v", err)
+	}
+	if !Capabilities(acc).Keepalive {
+		t.Fatal("keepalive not supported")
+	}
+
+	errCh := make(chan error, 10)
+	acc.StartKeepalive(ctx, 10*time.Millisecond, func(err error) { errCh <- err })
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, common.ErrSessionExpired) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("keepalive error not reported")
+	}
+	acc.Logout(ctx)
+}
+
+func TestTransfer(t *testing.T) {
+	acc, err := LoginWithJsonFile("examples/accounts/stub.json")
+	if err != nil {
+		t.Fatalf("login failed %v", err)
+	}
+
+	tr, err := acc.NewTransferToRegisteredAccount("test", 1000)
+	if err != nil {
+		t.Fatalf("failed to create transfer: %v", err)
+	}
+	if tr.Amount != common.Yen(1000) || tr.Total != tr.Amount.Add(tr.Fee) || tr.PayeeName != "test" {
+		t.Errorf("unexpected quote: %v", tr)
+	}
+	t.Log("Transfer: ", tr)
+
+	recptNo, err := acc.CommitTransfer(tr, "1234")
+	if err != nil {
+		t.Errorf("failed to commit transfer: %v", err)
+	}
+	t.Log("Receipt: ", recptNo)
+}
+
+func TestAccounts(t *testing.T) {
+	acc, err := LoginWithJsonFileContext(context.Background(), "examples/accounts/stub.json")
+	if err != nil {
+		t.Fatalf("login failed %v", err)
+	}
+	if !Capabilities(acc).Accounts {
+		t.Fatalf("stub should implement MultiAccount")
+	}
+
+	accounts, err := acc.(common.MultiAccount).Accounts(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get accounts: %v", err)
+	}
+	if len(accounts) != 2 {
+		t.Errorf("unexpected accounts: %v", accounts)
+	}
+	for _, sub := range accounts {
+		balance, err := sub.Balance(context.Background())
+		if err != nil {
+			t.Errorf("failed to get balance: %v", err)
+		}
+		t.Log(sub.AccountInfo().AccountNum, balance)
+	}
+}
+
+func TestSession(t *testing.T) {
+	ctx := context.Background()
+	key := []byte("secret")
+	c, err := LoadAccountConfig("examples/accounts/stub.json")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	acc, err := LoginContext(ctx, c)
+	if err != nil {
+		t.Fatalf("login failed %v", err)
+	}
+
+	data, err := SaveSession(acc, key)
+	if err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	resumed, err := Resume(ctx, c, data, key)
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	if resumed.AccountInfo().OwnerName != acc.AccountInfo().OwnerName {
+		t.Errorf("unexpected account: %v", resumed.AccountInfo())
+	}
+
+	if _, err := Resume(ctx, c, data, []byte("wrong key")); err == nil {
+		t.Errorf("resume with wrong key should fail")
+	}
+
+	// fallback to login
+	expired := *c
+	expired.Options = map[string]interface{}{"expired": true}
+	if _, err := Resume(ctx, &expired, data, key); err != nil {
+		t.Errorf("failed to login: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package banking
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/binzume/gobanking/common"
+	"github.com/binzume/gobanking/utils"
+)
+
+// ErrCircuitOpen means login was refused to avoid account lockout. see *CircuitOpenError.
+var ErrCircuitOpen = errors.New("login refused after repeated failures")
+
+type CircuitOpenError struct {
+	Bank      string
+	ID        string
+	Failures  int
+	LastError string
+	RetryAt   time.Time // zero if manual reset is required.
+}
+
+func (e *CircuitOpenError) Error() string {
+	s := fmt.Sprintf("%v: %s:%s failed %d times (%s)", ErrCircuitOpen, e.Bank, e.ID, e.Failures, e.LastError)
+	if e.RetryAt.IsZero() {
+		return s + ", reset required"
+	}
+	return s + ", retry after " + e.RetryAt.Format(time.RFC3339)
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+type loginFailure struct {
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last_error"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+// LoginBreaker counts consecutive login failures per (bank, id) and refuses
+// further logins after MaxFailures until Reset is called, CoolDown passes or the entry is removed from Path.
+// Only rejected credentials (common.ErrLoginRejected, common.ErrAccountLocked) are counted.
+// Methods are safe for concurrent use.
+type LoginBreaker struct {
+	Path        string        // JSON file to persist counters. Empty means in-memory.
+	MaxFailures int           // default: 3
+	CoolDown    time.Duration // zero means manual reset only.
+
+	mu       sync.Mutex
+	failures map[string]*loginFailure
+}
+
+// SetLoginBreaker enables b for all LoginContext calls and re-logins by the "relogin" option. nil disables it.
+func SetLoginBreaker(b *LoginBreaker) {
+	if b == nil {
+		utils.SetLoginGuard(nil)
+		return
+	}
+	utils.SetLoginGuard(b)
+}
+
+func breakerKey(bank, id string) string {
+	return bank + ":" + id
+}
+
+// load reads the counters. The file is read every time so that changes by other processes
+// (or removing the file) take effect without restarting.
+func (b *LoginBreaker) load() error {
+	if b.Path == "" {
+		if b.failures == nil {
+			b.failures = map[string]*loginFailure{}
+		}
+		return nil
+	}
+	b.failures = map[string]*loginFailure{}
+	buf, err := ioutil.ReadFile(b.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, &b.failures)
+}
+
+func (b *LoginBreaker) save() error {
+	if b.Path == "" {
+		return nil
+	}
+	buf, err := json.Marshal(b.failures)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(b.Path), "."+filepath.Base(b.Path)+".tmp")
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.Path)
+}
+
+// Allow returns a *CircuitOpenError if login to the account is refused.
+func (b *LoginBreaker) Allow(bank, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.load(); err != nil {
+		return err
+	}
+	max := b.MaxFailures
+	if max <= 0 {
+		max = 3
+	}
+	f := b.failures[breakerKey(bank, id)]
+	if f == nil || f.Failures < max {
+		return nil
+	}
+	e := &CircuitOpenError{Bank: bank, ID: id, Failures: f.Failures, LastError: f.LastError}
+	if b.CoolDown > 0 {
+		e.RetryAt = f.LastFailure.Add(b.CoolDown)
+		if time.Now().After(e.RetryAt) {
+			return nil // half-open: allow one attempt.
+		}
+	}
+	return e
+}
+
+// Record updates the counter by the result of a login.
+func (b *LoginBreaker) Record(bank, id string, loginErr error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.load(); err != nil {
+		return err
+	}
+	key := breakerKey(bank, id)
+	if loginErr == nil {
+		if _, ok := b.failures[key]; !ok {
+			return nil
+		}
+		delete(b.failures, key)
+	} else if errors.Is(loginErr, common.ErrLoginRejected) || errors.Is(loginErr, common.ErrAccountLocked) {
+		f := b.failures[key]
+		if f == nil {
+			f = &loginFailure{}
+			b.failures[key] = f
+		}
+		f.Failures++
+		f.LastError = loginErr.Error()
+		f.LastFailure = time.Now()
+	} else {
+		return nil // network errors, maintenance, etc.
+	}
+	return b.save()
+}
+
+// Reset clears the counter of the account.
+func (b *LoginBreaker) Reset(bank, id string) error {
+	return b.Record(bank, id, nil)
+}
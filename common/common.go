@@ -1,19 +1,72 @@
 package common
 
 import (
+	"context"
+	"fmt"
 	"time"
 )
 
 type Account interface {
-	Login(id, password string, options map[string]interface{}) error // Internal use only. see: bankpkg.Login(...)
-	Logout() error
+	Login(ctx context.Context, id, password string, options map[string]interface{}) error // Internal use only. see: bankpkg.Login(...)
+	Logout(ctx context.Context) error
 	AccountInfo() *BankAccount
-	TotalBalance() (int64, error)
-	LastLogin() (time.Time, error)
-	Recent() ([]*Transaction, error)
-	History(from, to time.Time) ([]*Transaction, error)
-	NewTransferToRegisteredAccount(targetName string, amount int64) (TransferState, error)
-	CommitTransfer(tr TransferState, passwd string) (string, error)
+	TotalBalance(ctx context.Context) (int64, error) // in JPY. Balances in other currencies are available by MultiAccount.
+	LastLogin(ctx context.Context) (time.Time, error)
+}
+
+// Optional interfaces. Use type assertion or banking.Capabilities(acc) to check.
+
+type HistoryProvider interface {
+	Recent(ctx context.Context) ([]*Transaction, error)
+	History(ctx context.Context, from, to time.Time) ([]*Transaction, error)
+}
+
+type Transferer interface {
+	NewTransferToRegisteredAccount(ctx context.Context, targetName string, amount int64) (*TransferQuote, error)
+	CommitTransfer(ctx context.Context, tr *TransferQuote, passwd string) (string, error)
+}
+
+type PayeeLister interface {
+	Payees(ctx context.Context) ([]*Payee, error)
+}
+
+type FxTrader interface {
+	NewFxTransfer(ctx context.Context, fromCur, toCur string, amount float32, pin string) (*TransferQuote, error)
+	CommitFxTransfer(ctx context.Context, tr *TransferQuote) (string, error)
+}
+
+type Refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+// KeepAliver keeps the login session alive in background.
+type KeepAliver interface {
+	// StartKeepalive sends a cheap request when the session is idle for the interval,
+	// until ctx is done or Logout is called. Failures are reported to onError.
+	// A non-positive interval is reported to onError and keepalive is not started.
+	StartKeepalive(ctx context.Context, interval time.Duration, onError func(error))
+}
+
+// MultiAccount lists accounts in a login session (savings, FX accounts, etc.)
+type MultiAccount interface {
+	Accounts(ctx context.Context) ([]SubAccount, error)
+}
+
+type SubAccount interface {
+	AccountInfo() *BankAccount
+	Balance(ctx context.Context) (Money, error)
+	History(ctx context.Context, from, to time.Time) ([]*Transaction, error)
+}
+
+// Capabilities lists optional interfaces implemented by an Account.
+type Capabilities struct {
+	History   bool // HistoryProvider
+	Transfer  bool // Transferer
+	Payees    bool // PayeeLister
+	Fx        bool // FxTrader
+	Refresh   bool // Refresher
+	Accounts  bool // MultiAccount
+	Keepalive bool // KeepAliver
 }
 
 type BankAccount struct {
@@ -25,14 +78,59 @@ type BankAccount struct {
 	OwnerName  string
 }
 
+// Payee is a registered transfer destination.
+type Payee struct {
+	Name string // targetName for NewTransferToRegisteredAccount()
+	BankAccount
+}
+
 type Transaction struct {
-	Date        time.Time `json:"date"`
-	Amount      int64     `json:"amount"`
-	Balance     int64     `json:"balance"`
-	Description string    `json:"description"`
+	Date         time.Time         `json:"date"`       // posting date
+	ValueDate    time.Time         `json:"value_date"` // zero if unknown
+	Amount       Money             `json:"amount"`
+	Balance      Money             `json:"balance"`
+	Description  string            `json:"description"`
+	Kind         TransactionKind   `json:"kind,omitempty"`
+	ReferenceID  string            `json:"reference_id,omitempty"` // bank reference number
+	Counterparty string            `json:"counterparty,omitempty"`
+	Raw          map[string]string `json:"raw,omitempty"` // fields returned by the bank as is
+}
+
+// TransferQuote is a transfer confirmed by the bank but not committed yet.
+type TransferQuote struct {
+	Amount        Money     `json:"amount"`
+	Fee           Money     `json:"fee"`
+	Total         Money     `json:"total"`
+	Rate          float64   `json:"rate,omitempty"` // exchange rate (FX only)
+	Converted     Money     `json:"converted"`      // converted amount (FX only)
+	ScheduledDate time.Time `json:"scheduled_date"`
+	ExpiresAt     time.Time `json:"expires_at"` // deadline to commit the quote. zero if unknown.
+
+	PayeeName    string `json:"payee_name"`
+	PayeeBank    string `json:"payee_bank"`
+	PayeeBranch  string `json:"payee_branch"`
+	PayeeAccount string `json:"payee_account"`
+
+	// State is a bank specific continuation for CommitTransfer(). Don't touch.
+	State interface{} `json:"-"`
+}
+
+func (q *TransferQuote) String() string {
+	s := fmt.Sprintf("TransferQuote{Payee:%q Bank:%q Branch:%q Account:%q Amount:%v Fee:%v Total:%v",
+		q.PayeeName, q.PayeeBank, q.PayeeBranch, q.PayeeAccount, q.Amount, q.Fee, q.Total)
+	if q.Rate != 0 {
+		s += fmt.Sprintf(" Rate:%v Converted:%v", q.Rate, q.Converted)
+	}
+	if !q.ScheduledDate.IsZero() {
+		s += " Date:" + q.ScheduledDate.Format("2006-01-02")
+	}
+	if !q.ExpiresAt.IsZero() {
+		s += " Expires:" + q.ExpiresAt.Format(time.RFC3339)
+	}
+	return s + "}"
 }
 
-type TransferState interface {
-	Amount() int64
-	FeeMessage() string
+// GoString hides State from %#v.
+func (q *TransferQuote) GoString() string {
+	return q.String()
 }
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Driver logs in to a bank. Bank packages register their drivers in init().
+type Driver interface {
+	Login(ctx context.Context, id, password string, options map[string]interface{}) (Account, error)
+}
+
+// DriverFunc adapts a login function to Driver.
+type DriverFunc func(ctx context.Context, id, password string, options map[string]interface{}) (Account, error)
+
+func (f DriverFunc) Login(ctx context.Context, id, password string, options map[string]interface{}) (Account, error) {
+	return f(ctx, id, password, options)
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]Driver{}
+)
+
+// Register makes a driver available by the provided name.
+// If Register is called twice with the same name or if driver is nil, it panics.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("banking: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("banking: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	list := make([]string, 0, len(drivers))
+	for name := range drivers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func LookupDriver(name string) (Driver, bool) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	d, ok := drivers[name]
+	return d, ok
+}
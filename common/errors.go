@@ -0,0 +1,106 @@
+package common
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrNotSupported is returned by operations the bank (or this library) doesn't support.
+var ErrNotSupported = errors.New("not supported")
+
+var (
+	ErrLoginRejected      = errors.New("login rejected")
+	ErrSessionExpired     = errors.New("session expired")
+	ErrAccountLocked      = errors.New("account locked")
+	ErrMaintenance        = errors.New("site under maintenance")
+	ErrPayeeNotRegistered = errors.New("payee not registered")
+	ErrInsufficientFunds  = errors.New("insufficient funds")
+	ErrLayoutChanged      = errors.New("layout changed")
+)
+
+// BankError is a failure reported by (or detected on) a bank site.
+// Use errors.Is(err, ErrXXX) to check the kind.
+type BankError struct {
+	Bank    string
+	Kind    error  // one of ErrXXX. nil if unclassified.
+	Message string // original message from the bank.
+
+	Snapshot string // path of the saved page for diagnostics, if any.
+}
+
+func NewBankError(bank string, kind error, msg string) *BankError {
+	return &BankError{Bank: bank, Kind: kind, Message: msg}
+}
+
+func (e *BankError) Error() string {
+	s := e.Bank + ": "
+	if e.Kind != nil {
+		s += e.Kind.Error()
+		if e.Message != "" {
+			s += ": "
+		}
+	}
+	s += e.Message
+	if e.Snapshot != "" {
+		s += " (snapshot: " + e.Snapshot + ")"
+	}
+	return s
+}
+
+func (e *BankError) Unwrap() error {
+	return e.Kind
+}
+
+// LayoutError is a field which is missing or can't be parsed on a page. It is an ErrLayoutChanged.
+type LayoutError struct {
+	Bank  string
+	Page  string // page ID or API name.
+	Field string
+	Value string // the invalid value. empty if missing.
+
+	Snapshot string // path of the saved page for diagnostics, if any.
+}
+
+func (e *LayoutError) Error() string {
+	s := e.Bank + ": " + ErrLayoutChanged.Error() + ": " + e.Field
+	if e.Value == "" {
+		s += " not found"
+	} else {
+		s += " invalid value " + strconv.Quote(e.Value)
+	}
+	s += " in " + e.Page
+	if e.Snapshot != "" {
+		s += " (snapshot: " + e.Snapshot + ")"
+	}
+	return s
+}
+
+func (e *LayoutError) Unwrap() error {
+	return ErrLayoutChanged
+}
+
+var messageKinds = []struct {
+	kind     error
+	keywords []string
+}{
+	{ErrAccountLocked, []string{"ロック", "利用停止", "凍結"}},
+	{ErrSessionExpired, []string{"セッション", "タイムアウト", "再度ログイン", "一定時間"}},
+	{ErrMaintenance, []string{"メンテナンス", "サービス時間外", "休止", "ただいま混み合"}},
+	{ErrInsufficientFunds, []string{"残高不足", "残高が不足", "支払可能額を超え", "支払可能残高を超え"}},
+	{ErrPayeeNotRegistered, []string{"登録されていません", "振込先が存在しません"}},
+	{ErrLoginRejected, []string{"パスワード", "暗証番号", "お客さま番号", "ログインID", "認証に失敗", "ログインできません"}},
+}
+
+// ClassifyMessage guesses the kind of an error message shown by bank sites.
+// Returns nil if unknown.
+func ClassifyMessage(msg string) error {
+	for _, k := range messageKinds {
+		for _, w := range k.keywords {
+			if strings.Contains(msg, w) {
+				return k.kind
+			}
+		}
+	}
+	return nil
+}
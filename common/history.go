@@ -0,0 +1,161 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIncompleteHistory means a part of the requested range couldn't be served. see *IncompleteHistoryError.
+var ErrIncompleteHistory = errors.New("history range not fully served")
+
+// HistoryLimits describes the range a bank can serve. Zero means unlimited.
+type HistoryLimits struct {
+	MaxMonths  int // how far back history is available.
+	WindowDays int // max days per request.
+	MaxRows    int // max rows per request. A window returning MaxRows rows is split and retried.
+}
+
+// HistoryLimiter is implemented by accounts whose History has limits.
+type HistoryLimiter interface {
+	HistoryLimits() HistoryLimits
+}
+
+type IncompleteHistoryError struct {
+	From, To             time.Time // requested range
+	ServedFrom, ServedTo time.Time // earliest and latest days fetched completely. Zero if none. (may have gaps)
+	Reason               string
+}
+
+func (e *IncompleteHistoryError) Error() string {
+	return fmt.Sprintf("%v: requested %s - %s, served %s - %s (%s)", ErrIncompleteHistory,
+		e.From.Format("2006-01-02"), e.To.Format("2006-01-02"),
+		e.ServedFrom.Format("2006-01-02"), e.ServedTo.Format("2006-01-02"), e.Reason)
+}
+
+func (e *IncompleteHistoryError) Unwrap() error {
+	return ErrIncompleteHistory
+}
+
+type HistoryFunc func(ctx context.Context, from, to time.Time) ([]*Transaction, error)
+
+var now = time.Now
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// FetchHistory fetches history in windows allowed by limits and merges the results in order.
+// If the range is clamped to the available horizon or a window is truncated by MaxRows,
+// the fetched transactions are returned with *IncompleteHistoryError. A reversed range is an error.
+func FetchHistory(ctx context.Context, limits HistoryLimits, from, to time.Time, fetch HistoryFunc) ([]*Transaction, error) {
+	today := truncateDay(now())
+	if to.IsZero() || to.After(today) {
+		to = today
+	}
+	to = truncateDay(to)
+	requested := from
+	var reason string
+	if limits.MaxMonths > 0 {
+		horizon := today.AddDate(0, -limits.MaxMonths, 0)
+		if from.IsZero() {
+			from = horizon
+		} else if from.Before(horizon) {
+			reason = fmt.Sprintf("available for %d months", limits.MaxMonths)
+			from = horizon
+		}
+	}
+	if from.IsZero() {
+		return fetch(ctx, from, to)
+	}
+	from = truncateDay(from)
+	if from.After(to) {
+		if reason != "" {
+			return nil, &IncompleteHistoryError{From: requested, To: to, Reason: reason}
+		}
+		return nil, fmt.Errorf("invalid history range: %s - %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+
+	var lists [][]*Transaction
+	var servedFrom, servedTo time.Time
+	for start := from; !start.After(to); {
+		end := to
+		if limits.WindowDays > 0 && end.After(start.AddDate(0, 0, limits.WindowDays-1)) {
+			end = start.AddDate(0, 0, limits.WindowDays-1)
+		}
+		trs, err := fetchWindow(ctx, limits, start, end, fetch)
+		if trs != nil {
+			lists = append(lists, trs)
+		}
+		var e *IncompleteHistoryError
+		if err != nil && !errors.As(err, &e) {
+			return MergeTransactions(lists...), err
+		}
+		if e != nil && reason == "" {
+			reason = e.Reason
+		}
+		servedFrom, servedTo = extendRange(servedFrom, servedTo, err, start, end)
+		start = end.AddDate(0, 0, 1)
+	}
+	if reason != "" {
+		return MergeTransactions(lists...), &IncompleteHistoryError{From: requested, To: to, ServedFrom: servedFrom, ServedTo: servedTo, Reason: reason}
+	}
+	return MergeTransactions(lists...), nil
+}
+
+func fetchWindow(ctx context.Context, limits HistoryLimits, from, to time.Time, fetch HistoryFunc) ([]*Transaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	trs, err := fetch(ctx, from, to)
+	if err != nil || limits.MaxRows <= 0 || len(trs) < limits.MaxRows {
+		return trs, err
+	}
+	if !from.Before(to) {
+		return trs, &IncompleteHistoryError{From: from, To: to,
+			Reason: fmt.Sprintf("more than %d rows in a day", limits.MaxRows)}
+	}
+	days := int(to.Sub(from).Hours()/24+0.5) + 1
+	mid := from.AddDate(0, 0, days/2-1)
+	first, err := fetchWindow(ctx, limits, from, mid, fetch)
+	if err != nil && !errors.Is(err, ErrIncompleteHistory) {
+		return first, err
+	}
+	second, err2 := fetchWindow(ctx, limits, mid.AddDate(0, 0, 1), to, fetch)
+	trs = MergeTransactions(first, second)
+	if err2 != nil && !errors.Is(err2, ErrIncompleteHistory) {
+		return trs, err2
+	}
+	var e1, e2 *IncompleteHistoryError
+	errors.As(err, &e1)
+	errors.As(err2, &e2)
+	if e1 == nil && e2 == nil {
+		return trs, nil
+	}
+	e := &IncompleteHistoryError{From: from, To: to}
+	if e1 != nil {
+		e.Reason = e1.Reason
+	} else {
+		e.Reason = e2.Reason
+	}
+	e.ServedFrom, e.ServedTo = extendRange(time.Time{}, time.Time{}, err, from, mid)
+	e.ServedFrom, e.ServedTo = extendRange(e.ServedFrom, e.ServedTo, err2, mid.AddDate(0, 0, 1), to)
+	return trs, e
+}
+
+// extendRange extends the served range [servedFrom, servedTo] by the window [from, to] fetched with err.
+// Windows must be given in order.
+func extendRange(servedFrom, servedTo time.Time, err error, from, to time.Time) (time.Time, time.Time) {
+	var e *IncompleteHistoryError
+	if errors.As(err, &e) {
+		from, to = e.ServedFrom, e.ServedTo
+	}
+	if from.IsZero() {
+		return servedFrom, servedTo
+	}
+	if servedFrom.IsZero() {
+		servedFrom = from
+	}
+	return servedFrom, to
+}
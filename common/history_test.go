@@ -0,0 +1,83 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFetchHistory(t *testing.T) {
+	now = func() time.Time { return time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+	day := func(m time.Month, d int) time.Time { return time.Date(2020, m, d, 0, 0, 0, 0, time.UTC) }
+
+	var requests [][2]time.Time
+	fetch := func(ctx context.Context, from, to time.Time) ([]*Transaction, error) {
+		requests = append(requests, [2]time.Time{from, to})
+		var trs []*Transaction
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			trs = append(trs, &Transaction{Date: d, Amount: Yen(1), Description: "daily"})
+		}
+		return trs, nil
+	}
+
+	limits := HistoryLimits{MaxMonths: 3, WindowDays: 31}
+	trs, err := FetchHistory(context.Background(), limits, day(1, 1), day(7, 15), fetch)
+	var e *IncompleteHistoryError
+	if !errors.As(err, &e) || !e.ServedFrom.Equal(day(3, 30)) || !e.ServedTo.Equal(day(6, 30)) {
+		t.Errorf("expected IncompleteHistoryError: %v", err)
+	}
+	if len(trs) != 93 || !trs[0].Date.Equal(day(3, 30)) || !trs[92].Date.Equal(day(6, 30)) {
+		t.Errorf("unexpected result: %d transactions", len(trs))
+	}
+	if len(requests) != 3 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	requests = nil
+	limits = HistoryLimits{MaxRows: 10}
+	trs, err = FetchHistory(context.Background(), limits, day(6, 1), day(6, 30), fetch)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(trs) != 30 {
+		t.Errorf("unexpected result: %d transactions", len(trs))
+	}
+	if len(requests) < 3 {
+		t.Errorf("window should be split: %v", requests)
+	}
+
+	// reversed range
+	requests = nil
+	if _, err := FetchHistory(context.Background(), HistoryLimits{}, day(6, 20), day(6, 10), fetch); err == nil || len(requests) != 0 {
+		t.Errorf("reversed range should be rejected: %v %v", err, requests)
+	}
+
+	// truncated in the middle and the last window
+	busy := func(ctx context.Context, from, to time.Time) ([]*Transaction, error) {
+		var trs []*Transaction
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			n := 1
+			if d.Equal(day(6, 15)) || d.Equal(day(6, 30)) {
+				n = 20
+			}
+			for i := 0; i < n; i++ {
+				trs = append(trs, &Transaction{Date: d, Amount: Yen(int64(i + 1)), Description: "busy"})
+			}
+		}
+		return trs, nil
+	}
+	limits = HistoryLimits{WindowDays: 10, MaxRows: 20}
+	trs, err = FetchHistory(context.Background(), limits, day(6, 1), day(6, 30), busy)
+	if !errors.As(err, &e) || !e.From.Equal(day(6, 1)) || !e.ServedFrom.Equal(day(6, 1)) || !e.ServedTo.Equal(day(6, 29)) {
+		t.Errorf("expected IncompleteHistoryError: %v", err)
+	}
+	if len(trs) != 68 {
+		t.Errorf("unexpected result: %d transactions", len(trs))
+	}
+	trs, err = FetchHistory(context.Background(), limits, day(6, 15), day(6, 20), busy)
+	if !errors.As(err, &e) || !e.ServedFrom.Equal(day(6, 16)) || !e.ServedTo.Equal(day(6, 20)) {
+		t.Errorf("expected IncompleteHistoryError: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"context"
+	"time"
+)
+
+// LegacyAccount is the method set of Account before context.Context support.
+type LegacyAccount interface {
+	Logout() error
+	AccountInfo() *BankAccount
+	TotalBalance() (int64, error)
+	LastLogin() (time.Time, error)
+	Recent() ([]*Transaction, error)
+	History(from, to time.Time) ([]*Transaction, error)
+	NewTransferToRegisteredAccount(targetName string, amount int64) (*TransferQuote, error)
+	CommitTransfer(tr *TransferQuote, passwd string) (string, error)
+}
+
+// Legacy adapts an Account to LegacyAccount. All calls use context.Background().
+type Legacy struct {
+	Account
+}
+
+func (a *Legacy) Logout() error {
+	return a.Account.Logout(context.Background())
+}
+
+func (a *Legacy) TotalBalance() (int64, error) {
+	return a.Account.TotalBalance(context.Background())
+}
+
+func (a *Legacy) LastLogin() (time.Time, error) {
+	return a.Account.LastLogin(context.Background())
+}
+
+func (a *Legacy) Recent() ([]*Transaction, error) {
+	if h, ok := a.Account.(HistoryProvider); ok {
+		return h.Recent(context.Background())
+	}
+	return nil, ErrNotSupported
+}
+
+func (a *Legacy) History(from, to time.Time) ([]*Transaction, error) {
+	if h, ok := a.Account.(HistoryProvider); ok {
+		return h.History(context.Background(), from, to)
+	}
+	return nil, ErrNotSupported
+}
+
+func (a *Legacy) NewTransferToRegisteredAccount(targetName string, amount int64) (*TransferQuote, error) {
+	if t, ok := a.Account.(Transferer); ok {
+		return t.NewTransferToRegisteredAccount(context.Background(), targetName, amount)
+	}
+	return nil, ErrNotSupported
+}
+
+func (a *Legacy) CommitTransfer(tr *TransferQuote, passwd string) (string, error) {
+	if t, ok := a.Account.(Transferer); ok {
+		return t.CommitTransfer(context.Background(), tr, passwd)
+	}
+	return "", ErrNotSupported
+}
@@ -0,0 +1,36 @@
+package common
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+// Logger receives structured logs from an account. kv is alternating keys and values.
+// Credentials are masked before passed to Logger.
+type Logger interface {
+	Log(level LogLevel, msg string, kv ...interface{})
+}
+
+type LoggerFunc func(level LogLevel, msg string, kv ...interface{})
+
+func (f LoggerFunc) Log(level LogLevel, msg string, kv ...interface{}) {
+	f(level, msg, kv...)
+}
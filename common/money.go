@@ -0,0 +1,106 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency code. Empty means JPY.
+type Currency string
+
+const JPY Currency = "JPY"
+
+var currencyDigits = map[Currency]int{
+	JPY:   0,
+	"KRW": 0,
+	"CLP": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// Digits returns number of minor unit digits. (JPY:0, USD:2, ...)
+func (c Currency) Digits() int {
+	if d, ok := currencyDigits[c]; ok || c == "" {
+		return d
+	}
+	return 2
+}
+
+func (c Currency) OrDefault() Currency {
+	if c == "" {
+		return JPY
+	}
+	return c
+}
+
+// Money is an amount in minor units of the currency. e.g. {1234, "USD"} is 12.34 USD.
+type Money struct {
+	Value    int64    `json:"value"`
+	Currency Currency `json:"currency,omitempty"`
+}
+
+func Yen(v int64) Money {
+	return Money{Value: v, Currency: JPY}
+}
+
+// ParseMoney parses decimal string like "1,234.56".
+func ParseMoney(s string, cur Currency) (Money, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", "", -1)
+	digits := cur.Digits()
+	intPart, fracPart := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if len(fracPart) > digits {
+		return Money{}, fmt.Errorf("too many decimal places for %v: %q", cur.OrDefault(), s)
+	}
+	fracPart += strings.Repeat("0", digits-len(fracPart))
+	v, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Value: v, Currency: cur.OrDefault()}, nil
+}
+
+// Add returns m + o. Panics if the currencies are different. The zero Money{} can be added to any currency.
+func (m Money) Add(o Money) Money {
+	if m == (Money{}) {
+		return o
+	}
+	if o != (Money{}) && m.Currency.OrDefault() != o.Currency.OrDefault() {
+		panic(fmt.Sprintf("currency mismatch: %v + %v", m.Currency.OrDefault(), o.Currency.OrDefault()))
+	}
+	return Money{Value: m.Value + o.Value, Currency: m.Currency}
+}
+
+func (m Money) Neg() Money {
+	return Money{Value: -m.Value, Currency: m.Currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Value == 0
+}
+
+// Decimal returns the amount as a decimal string. e.g. "-12.34"
+func (m Money) Decimal() string {
+	digits := m.Currency.Digits()
+	v := m.Value
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	s := strconv.FormatInt(v, 10)
+	if digits == 0 {
+		return sign + s
+	}
+	if len(s) <= digits {
+		s = strings.Repeat("0", digits-len(s)+1) + s
+	}
+	return sign + s[:len(s)-digits] + "." + s[len(s)-digits:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + string(m.Currency.OrDefault())
+}
@@ -0,0 +1,50 @@
+package common
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		s    string
+		cur  Currency
+		want Money
+		str  string
+	}{
+		{"1,234", JPY, Money{1234, JPY}, "1234 JPY"},
+		{"-500", "", Money{-500, JPY}, "-500 JPY"},
+		{"12.34", "USD", Money{1234, "USD"}, "12.34 USD"},
+		{"0.5", "EUR", Money{50, "EUR"}, "0.50 EUR"},
+		{"-0.05", "USD", Money{-5, "USD"}, "-0.05 USD"},
+	}
+	for _, c := range cases {
+		m, err := ParseMoney(c.s, c.cur)
+		if err != nil {
+			t.Errorf("ParseMoney(%q) error: %v", c.s, err)
+		}
+		if m != c.want {
+			t.Errorf("ParseMoney(%q) = %v, want %v", c.s, m, c.want)
+		}
+		if m.String() != c.str {
+			t.Errorf("String() = %q, want %q", m.String(), c.str)
+		}
+	}
+
+	if _, err := ParseMoney("1.5", JPY); err == nil {
+		t.Errorf("expected error for fractional yen")
+	}
+}
+
+func TestMoneyAdd(t *testing.T) {
+	if m := Yen(100).Add(Money{Value: 20}); m != Yen(120) {
+		t.Errorf("unexpected result: %v", m)
+	}
+	if m := (Money{}).Add(Money{150, "USD"}); m != (Money{150, "USD"}) {
+		t.Errorf("unexpected result: %v", m)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("adding different currencies should panic")
+		}
+	}()
+	Yen(100).Add(Money{150, "USD"})
+}
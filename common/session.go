@@ -0,0 +1,42 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Session is a serializable login session. see: banking.SaveSession(), banking.Resume()
+type Session struct {
+	Bank    string            `json:"bank"`
+	ID      string            `json:"id"`
+	Cookies []*SessionCookie  `json:"cookies"`
+	Values  map[string]string `json:"values"` // bank specific state
+	SavedAt time.Time         `json:"saved_at"`
+}
+
+type SessionCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// SessionSaver is implemented by accounts which can save the login session.
+type SessionSaver interface {
+	SaveSession() (*Session, error)
+}
+
+// SessionDriver is implemented by drivers which can resume saved sessions.
+// Resume returns an error wrapping ErrSessionExpired if the bank rejects the session.
+type SessionDriver interface {
+	Resume(ctx context.Context, s *Session, options map[string]interface{}) (Account, error)
+}
+
+// SessionRejected converts a bank error to ErrSessionExpired. Other errors (e.g. network errors) are returned as is.
+func SessionRejected(bank string, err error) error {
+	var be *BankError
+	if err == nil || errors.Is(err, ErrSessionExpired) || !errors.As(err, &be) {
+		return err
+	}
+	return NewBankError(bank, ErrSessionExpired, be.Error())
+}
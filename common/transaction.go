@@ -0,0 +1,128 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/width"
+)
+
+type TransactionKind string
+
+const (
+	KindUnknown  TransactionKind = ""
+	KindTransfer TransactionKind = "transfer"
+	KindATM      TransactionKind = "atm"
+	KindCard     TransactionKind = "card"
+	KindFee      TransactionKind = "fee"
+	KindInterest TransactionKind = "interest"
+	KindTax      TransactionKind = "tax"
+)
+
+// order matters. e.g. "振込手数料" is a fee.
+var descriptionKinds = []struct {
+	kind     TransactionKind
+	keywords []string
+}{
+	{KindTax, []string{"国税", "地方税", "税金"}},
+	{KindFee, []string{"手数料"}},
+	{KindInterest, []string{"利息", "利子"}},
+	{KindATM, []string{"ATM"}},
+	{KindCard, []string{"デビット", "VISA", "JCB", "カード"}},
+	{KindTransfer, []string{"振込", "振替", "フリコミ", "送金"}},
+}
+
+// ParseDescription guesses the kind and the counterparty of a transaction from its description.
+// e.g. "振込 ヤマダ タロウ" -> (KindTransfer, "ヤマダ タロウ")
+func ParseDescription(desc string) (TransactionKind, string) {
+	folded := strings.ToUpper(width.Fold.String(desc))
+	for _, k := range descriptionKinds {
+		for _, w := range k.keywords {
+			i := strings.Index(folded, w)
+			if i < 0 {
+				continue
+			}
+			if k.kind != KindTransfer {
+				return k.kind, ""
+			}
+			counterparty := strings.TrimLeft(folded[i+len(w):], " *＊　")
+			return k.kind, strings.TrimSpace(counterparty)
+		}
+	}
+	return KindUnknown, ""
+}
+
+// Fingerprint returns a stable key of the transaction.
+// The bank reference is used if available. Otherwise, date, amount and description are used
+// and seq distinguishes identical entries. (see Fingerprints)
+// Balance is not used because some banks return no balance in History.
+func (t *Transaction) Fingerprint(seq int) string {
+	var key string
+	if t.ReferenceID != "" {
+		key = fmt.Sprintf("ref\x00%s\x00%s", t.Date.Format("2006-01-02"), t.ReferenceID)
+	} else {
+		key = fmt.Sprintf("%s\x00%d", t.identityKey(), seq)
+	}
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:16])
+}
+
+func (t *Transaction) identityKey() string {
+	return t.Date.Format("2006-01-02") + "\x00" + t.Amount.String() + "\x00" + t.Description
+}
+
+// Fingerprints returns fingerprints of trs. Identical entries are numbered in order of appearance.
+func Fingerprints(trs []*Transaction) []string {
+	seq := map[string]int{}
+	result := make([]string, len(trs))
+	for i, tr := range trs {
+		k := tr.identityKey()
+		result[i] = tr.Fingerprint(seq[k])
+		seq[k]++
+	}
+	return result
+}
+
+// MergeTransactions unions transactions fetched by repeated or overlapping requests.
+// Entries are identified like Fingerprints: by the bank reference, or by date, amount, description and the order of identical entries.
+// Balances are compared only if both are known, because some banks return no balance in History.
+// Then the entry with the balance is kept.
+// The result is sorted by date (oldest first). Entries in the same day keep the order of appearance.
+func MergeTransactions(lists ...[]*Transaction) []*Transaction {
+	seen := map[string][]int{} // key -> indexes of merged
+	var merged []*Transaction
+	for _, trs := range lists {
+		seq := map[string]int{}
+		for _, tr := range trs {
+			var key string
+			if tr.ReferenceID != "" {
+				key = "ref\x00" + tr.Date.Format("2006-01-02") + "\x00" + tr.ReferenceID
+			} else {
+				k := tr.identityKey()
+				key = fmt.Sprintf("%s\x00%d", k, seq[k])
+				seq[k]++
+			}
+			dup := false
+			for _, i := range seen[key] {
+				if m := merged[i]; m.Balance == (Money{}) || tr.Balance == (Money{}) || m.Balance == tr.Balance {
+					if m.Balance == (Money{}) && tr.Balance != (Money{}) {
+						merged[i] = tr
+					}
+					dup = true
+					break
+				}
+			}
+			if !dup {
+				seen[key] = append(seen[key], len(merged))
+				merged = append(merged, tr)
+			}
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Date.Before(merged[j].Date)
+	})
+	return merged
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDescription(t *testing.T) {
+	cases := []struct {
+		desc         string
+		kind         TransactionKind
+		counterparty string
+	}{
+		{"振込　ヤマダ　タロウ", KindTransfer, "ヤマダ タロウ"},
+		{"振込＊ヤマダタロウ", KindTransfer, "ヤマダタロウ"},
+		{"振込手数料", KindFee, ""},
+		{"ＡＴＭ", KindATM, ""},
+		{"利息", KindInterest, ""},
+		{"国税", KindTax, ""},
+		{"給与", KindUnknown, ""},
+	}
+	for _, c := range cases {
+		kind, counterparty := ParseDescription(c.desc)
+		if kind != c.kind || counterparty != c.counterparty {
+			t.Errorf("ParseDescription(%q) = %q, %q, want %q, %q", c.desc, kind, counterparty, c.kind, c.counterparty)
+		}
+	}
+}
+
+func TestMergeTransactions(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	a := []*Transaction{
+		{Date: day(1), Amount: Yen(100), Description: "a"},
+		{Date: day(2), Amount: Yen(-50), Description: "b"},
+		{Date: day(2), Amount: Yen(-50), Description: "b"},
+	}
+	b := []*Transaction{
+		{Date: day(2), Amount: Yen(-50), Description: "b"},
+		{Date: day(2), Amount: Yen(-50), Description: "b"},
+		{Date: day(3), Amount: Yen(10), Description: "c", ReferenceID: "123"},
+	}
+	c := []*Transaction{
+		{Date: day(3), Amount: Yen(10), Description: "c (renamed)", ReferenceID: "123"},
+	}
+
+	fa, fb := Fingerprints(a), Fingerprints(b)
+	if fa[1] == fa[2] {
+		t.Errorf("identical entries must have different fingerprints")
+	}
+	if fa[1] != fb[0] || fa[2] != fb[1] {
+		t.Errorf("fingerprints must be stable")
+	}
+	withBalance := &Transaction{Date: day(1), Amount: Yen(100), Balance: Yen(1100), Description: "a"}
+	if withBalance.Fingerprint(0) != fa[0] {
+		t.Errorf("balance must not change the fingerprint")
+	}
+
+	merged := MergeTransactions(b, a, c)
+	if len(merged) != 4 {
+		t.Fatalf("unexpected merged length: %d", len(merged))
+	}
+	for i, desc := range []string{"a", "b", "b", "c"} {
+		if merged[i].Description != desc {
+			t.Errorf("merged[%d] = %v, want %v", i, merged[i].Description, desc)
+		}
+	}
+
+	// balance is unknown in one side.
+	withBalances := []*Transaction{{Date: day(1), Amount: Yen(100), Balance: Yen(1100), Description: "a"}}
+	otherBalance := []*Transaction{{Date: day(1), Amount: Yen(100), Balance: Yen(2100), Description: "a"}}
+	merged = MergeTransactions(a[:1], withBalances, otherBalance)
+	if len(merged) != 2 || merged[0].Balance != Yen(1100) || merged[1].Balance != Yen(2100) {
+		t.Errorf("unexpected merged: %v", merged)
+	}
+}
@@ -2,4 +2,7 @@ module github.com/binzume/gobanking
 
 go 1.16
 
-require golang.org/x/text v0.20.0
+require (
+	golang.org/x/net v0.31.0
+	golang.org/x/text v0.20.0
+)
@@ -0,0 +1,272 @@
+package mizuho
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/binzume/gobanking/utils"
+)
+
+const (
+	testID       = "1234567890"
+	testPassword = "password"
+	testQuestion = "母親の旧姓は？"
+	testAnswer   = "やまだ"
+	testPass2    = "9876543210"
+	testPayee    = "テスト太郎"
+)
+
+// fakeServer emulates Mizuho Direct. Pages are Shift_JIS and every form has a new POSTKEY.
+type fakeServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu        sync.Mutex
+	seq       int
+	postKey   string
+	loggedIn  bool
+	step      string // expected next page in the login flow
+	digits    []int
+	amount    int64
+	committed []int64 // committed transfer amounts
+
+	redesigned bool   // the top page has no txtCrntBal
+	utf8       bool   // pages are UTF-8 declared only by <meta charset> and forms are accept-charset="UTF-8"
+	history    string // rows of the history page instead of the default
+	badQuote   bool   // the transfer confirmation page has no amount
+	base       string // <base href> written in pages
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// options returns login options to use the fake server.
+func (s *fakeServer) options() map[string]interface{} {
+	return map[string]interface{}{utils.BaseURLKey: s.URL + "/servlet/"}
+}
+
+// expire invalidates the current session.
+func (s *fakeServer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loggedIn = false
+}
+
+func (s *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ParseForm()
+	page := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/servlet/"), ".do")
+
+	if page == "LOGBNK0000000B" {
+		s.step = "LOGBNK0000001B"
+		s.write(w, `<form name="LOGBNK_00000B"><input type="text" name="txbCustNo" value=""></form>`)
+		return
+	}
+	if r.FormValue("POSTKEY") != s.postKey {
+		s.error(w, "不正な画面遷移です。")
+		return
+	}
+
+	switch page {
+	case "LOGBNK0000001B":
+		if s.step != page || r.FormValue("txbCustNo") != testID {
+			s.error(w, "お客さま番号が正しくありません。")
+			return
+		}
+		s.step = "LOGWRD0010001B"
+		s.write(w, `<span id="txtQuery">`+testQuestion+`</span><input type="text" name="txbTestWord" value="">`)
+	case "LOGWRD0010001B":
+		if s.step != page || r.FormValue("txbTestWord") != s.encode(testAnswer) {
+			s.error(w, "合言葉の認証に失敗しました。")
+			return
+		}
+		s.step = "LOGBNK0000501B"
+		s.write(w, `<input type="password" name="PASSWD_LoginPwdInput" value="">`)
+	case "LOGBNK0000501B":
+		if s.step != page || r.FormValue("PASSWD_LoginPwdInput") != testPassword {
+			s.error(w, "ログインパスワードが正しくありません。")
+			return
+		}
+		s.step = ""
+		s.loggedIn = true
+		s.write(w, s.topPage())
+	case "MENSRV0100901B":
+		s.loggedIn = false
+		s.write(w, `ログアウトしました。`)
+	default:
+		if !s.loggedIn {
+			s.error(w, "一定時間操作がなかったため、ログアウトしました。再度ログインしてください。")
+			return
+		}
+		s.handleMenu(w, r, page)
+	}
+}
+
+func (s *fakeServer) handleMenu(w http.ResponseWriter, r *http.Request, page string) {
+	switch page {
+	case "MENSRV0100001B":
+		s.write(w, s.topPage())
+	case "MENSRV0100003B":
+		s.write(w, `<select name="lstAccSel"><option value="0">本店 普通 1234567</option><option value="1">渋谷支店 普通 7654321</option></select>`)
+	case "ACCHST0400001B":
+		if r.FormValue("lstDateFrmYear") == "" || r.FormValue("lstAccSel") == "" {
+			s.error(w, "照会期間を指定してください。")
+			return
+		}
+		from := r.FormValue("lstDateFrmYear") + "." + pad2(r.FormValue("lstDateFrmMnth")) + "." + pad2(r.FormValue("lstDateFrmDay"))
+		balance := `<span id="txtCrntBal">1,234,567</span>円`
+		if r.FormValue("lstAccSel") == "1" {
+			balance = `<span id="txtCrntBal">300,000</span>円`
+		}
+		if s.history != "" {
+			s.write(w, balance+`<table>`+s.history+`</table>`)
+			return
+		}
+		s.write(w, balance+`<table>`+historyRow(1, from, "カード", -3000)+historyRow(2, from, "振込 ヤマダ　ハナコ", 20000)+`</table>`)
+	case "MENSRV0100004B":
+		s.write(w, `<span id="txtNickNm_001">`+testPayee+`</span><span id="txtNickNm_002">家賃</span>`)
+	case "TRNTRN0500001B":
+		if r.FormValue("rdoTrnsfreeSel") != "1" {
+			s.error(w, "振込先を選択してください。")
+			return
+		}
+		s.write(w, `<input type="text" name="txbTrnfrAmnt" value="">`)
+	case "TRNTRN0507001B":
+		amount, err := strconv.ParseInt(r.FormValue("txbTrnfrAmnt"), 10, 64)
+		if err != nil || amount <= 0 {
+			s.error(w, "振込金額を正しく入力してください。")
+			return
+		}
+		if amount > 1000000 {
+			s.error(w, "支払可能残高を超えています。")
+			return
+		}
+		s.amount = amount
+		s.digits = []int{2, 5, 7, 10}
+		quoted := comma(amount)
+		if s.badQuote {
+			quoted = "-"
+		}
+		digits := ""
+		for i, d := range s.digits {
+			digits += fmt.Sprintf(`<span id="txtScndPwdDgt%d">%d</span>`, i+1, d)
+		}
+		s.write(w, digits+
+			`<span id="txtTrnfrAmnt">`+quoted+`</span>円`+
+			`<span id="txtTrnfrFee">220</span>円`+
+			`<span id="txtTrnfrAppDate">2020年01月10日</span>`+
+			`<span id="txtBnkNm">テスト銀行</span><span id="txtBrnchNm">本店</span>`+
+			`<span id="txtAccNo">1111111</span><span id="txtPayeeNm">ﾃｽﾄ ﾀﾛｳ</span>`)
+	case "TRNTRN0508001B":
+		for i, d := range s.digits {
+			if r.FormValue(fmt.Sprintf("PASSWD_ScndPwd%d", i+1)) != string(testPass2[d-1]) {
+				s.error(w, "確認用パスワードが正しくありません。")
+				return
+			}
+		}
+		if s.digits == nil || r.FormValue("chkTrnfrCntntConf") != "on" {
+			s.error(w, "不正な画面遷移です。")
+			return
+		}
+		s.digits = nil
+		s.committed = append(s.committed, s.amount)
+		s.write(w, fmt.Sprintf(`<span id="txtRecptNo">R%04d</span>`, len(s.committed)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeServer) topPage() string {
+	if s.redesigned {
+		return `<div class="balance"><span id="txtBalance">1,234,567</span>円</div><span id="txtAccNo">1234567</span>`
+	}
+	return `<meta property="page.branchcd" content="123">
+<span id="txtLoginInfoCustNm">みずほ　太郎</span>
+<span id="txtLastUsgTm">2020.01.02 03:04</span>
+<span id="txtBrnch">本店</span><span id="txtAccNo">1234567</span>
+<span id="txtCrntBal">1,234,567</span>円
+<table>` + historyRow(1, "2020.01.01", "ATM", -10000) + historyRow(2, "2020.01.02", "振込 ミズホ　ジロウ", 50000) + `</table>`
+}
+
+func historyRow(i int, date, desc string, amount int64) string {
+	draw, dpst := "", ""
+	if amount < 0 {
+		draw = comma(-amount) + "円"
+	} else {
+		dpst = comma(amount) + "円"
+	}
+	return fmt.Sprintf(`<tr><td><span id="txtDate_%03d">%s</span></td><td><span id="txtTransCntnt_%03d">%s</span></td>`+
+		`<td><span id="txtDrawAmnt_%03d">%s</span></td><td><span id="txtDpstAmnt_%03d">%s</span></td></tr>`+"\n",
+		i, date, i, desc, i, draw, i, dpst)
+}
+
+// encode returns s in the charset of pages.
+func (s *fakeServer) encode(str string) string {
+	if s.utf8 {
+		return str
+	}
+	return utils.ToSJIS(str)
+}
+
+// writePage writes a page in the charset.
+func (s *fakeServer) writePage(w http.ResponseWriter, page string) {
+	if s.utf8 {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, strings.Replace(page, "<html>", `<html><meta charset="UTF-8">`, 1))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=Shift_JIS")
+	fmt.Fprint(w, utils.ToSJIS(page))
+}
+
+// write writes a page with new hidden inputs.
+func (s *fakeServer) write(w http.ResponseWriter, body string) {
+	s.seq++
+	s.postKey = fmt.Sprintf("KEY%06d", s.seq)
+	form := `<form>`
+	if s.utf8 {
+		form = `<form accept-charset="UTF-8">`
+	}
+	if s.base != "" {
+		form = `<base href="` + s.base + `">` + form
+	}
+	s.writePage(w, `<html><body>`+form+body+
+		`<input type="hidden" name="_FRAMEID" value="F1">`+
+		`<input type="hidden" name="_TARGETID" value="T1">`+
+		`<input type="hidden" name="_LUID" value="L1">`+
+		`<input type="hidden" name="_SUBINDEX" value="0">`+
+		fmt.Sprintf(`<input type="hidden" name="_TOKEN" value="TOKEN%d">`, s.seq)+
+		`<input type="hidden" name="_FORMID" value="FORM">`+
+		`<input type="hidden" name="POSTKEY" value="`+s.postKey+`">`+
+		`</form></body></html>`)
+}
+
+// error writes an error page. Error pages have no POSTKEY.
+func (s *fakeServer) error(w http.ResponseWriter, msg string) {
+	s.writePage(w, `<html><body><div class="error" id="ErrorMessage">`+msg+`</div></body></html>`)
+}
+
+func pad2(s string) string {
+	if len(s) < 2 {
+		return "0" + s
+	}
+	return s
+}
+
+func comma(v int64) string {
+	s := strconv.FormatInt(v, 10)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,41 @@
+package mizuho
+
+import (
+	"testing"
+	"time"
+
+	"github.com/binzume/gobanking/common"
+	"github.com/binzume/gobanking/utils"
+	"github.com/binzume/gobanking/utils/dom"
+	"github.com/binzume/gobanking/utils/golden"
+)
+
+// newParser returns an Account to parse pages in strict mode without a session.
+func newParser() *Account {
+	log := utils.NewLog(nil, bankID)
+	return &Account{log: log, check: utils.NewChecker(nil, bankID, log, nil)}
+}
+
+func TestGoldenTopPage(t *testing.T) {
+	a := newParser()
+	if err := a.parseTopPage("MENSRV0100001B", golden.Load(t, "top.html")); err != nil {
+		t.Fatal(err)
+	}
+	golden.Assert(t, "top.golden.json", struct {
+		Account   common.BankAccount    `json:"account"`
+		Balance   int64                 `json:"balance"`
+		LastLogin time.Time             `json:"last_login"`
+		Recent    []*common.Transaction `json:"recent"`
+	}{a.BankAccount, a.balance, a.lastLogin, a.recent})
+}
+
+func TestGoldenHistory(t *testing.T) {
+	a := newParser()
+	page := golden.Load(t, "history.html")
+	check := a.check.Page("ACCHST0400001B", page)
+	trs := a.parseHistory(dom.Parse(page), -1, check)
+	if err := check.Err(); err != nil {
+		t.Fatal(err)
+	}
+	golden.Assert(t, "history.golden.json", trs)
+}
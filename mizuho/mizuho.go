@@ -1,23 +1,21 @@
 package mizuho
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"html"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/binzume/gobanking/common"
 	"github.com/binzume/gobanking/utils"
-
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/transform"
+	"github.com/binzume/gobanking/utils/dom"
 )
 
 // Mizuho Direct
@@ -25,41 +23,121 @@ type Account struct {
 	common.BankAccount
 
 	client  *http.Client
+	log     *utils.Log
+	diag    *utils.Diagnostics
+	check   *utils.Checker
+	id      string
 	form    map[string]string
+	charset string // charset to send the form
 	baseUrl string
+	fixBase bool // base_url is specified. <base href> in pages is not followed.
 
 	recent    []*common.Transaction
 	balance   int64
 	lastLogin time.Time
+
+	relogin   *utils.Relogin
+	keepalive utils.Keepalive
+}
+
+// hidden inputs to be sent with the next request.
+var formKeys = []string{"_FRAMEID", "_TARGETID", "_LUID", "_SUBINDEX", "_TOKEN", "_FORMID", "POSTKEY"}
+
+type transferState struct {
+	pass2Digits []int
+	next        string
 }
-type TransferState map[string]interface{}
 
+const bankID = "mizuho"
 const BankCode = "0001"
 const BankName = "みずほ銀行"
 const MizuhoUrl = "https://web1.ib.mizuhobank.co.jp/servlet/"
 const DummyFingerPrint = "version%3D3%2E2%2E0%2E0%5F3%26pm%5Ffpua%3Dmozilla"
 
-func Login(id, password string, options map[string]interface{}) (*Account, error) {
-	client, err := utils.NewHttpClient()
+func init() {
+	common.Register(bankID, driver{})
+	utils.SetRateLimit("mizuhobank.co.jp", utils.RateLimit{Interval: time.Second, Burst: 3})
+}
+
+type driver struct{}
+
+func (driver) Login(ctx context.Context, id, password string, options map[string]interface{}) (common.Account, error) {
+	return LoginContext(ctx, id, password, options)
+}
+
+func (driver) Resume(ctx context.Context, s *common.Session, options map[string]interface{}) (common.Account, error) {
+	return Resume(ctx, s, options)
+}
+
+// Login is LoginContext without context. Parsing is lenient unless options["strict"] is specified.
+// The returned LegacyAccount has the old methods without context for compatibility.
+func Login(id, password string, options map[string]interface{}) (*LegacyAccount, error) {
+	a, err := LoginContext(context.Background(), id, password, utils.Lenient(options))
+	if a == nil {
+		return nil, err
+	}
+	return newLegacyAccount(a), err
+}
+
+func LoginContext(ctx context.Context, id, password string, options map[string]interface{}) (*Account, error) {
+	log := utils.NewLog(options, bankID)
+	client, err := utils.NewHttpClientFromOptions(options, log)
 	if err != nil {
 		return nil, err
 	}
-	a := &Account{client: client, baseUrl: MizuhoUrl}
-	err = a.Login(id, password, options)
+	diag := utils.NewDiagnostics(options, bankID, log)
+	a := &Account{client: client, log: log, diag: diag, check: utils.NewChecker(options, bankID, log, diag), baseUrl: utils.OptionURL(options, utils.BaseURLKey, MizuhoUrl), fixBase: options[utils.BaseURLKey] != nil}
+	err = a.Login(ctx, id, password, options)
 	return a, err
 }
 
-func (a *Account) Logout() error {
-	_, err := a.fetch("MENSRV0100901B")
+// Resume restores a session saved by SaveSession().
+func Resume(ctx context.Context, s *common.Session, options map[string]interface{}) (*Account, error) {
+	log := utils.NewLog(options, bankID)
+	client, err := utils.NewHttpClientFromOptions(options, log)
+	if err != nil {
+		return nil, err
+	}
+	err = utils.ImportCookies(client, s.Cookies)
+	if err != nil {
+		return nil, err
+	}
+	diag := utils.NewDiagnostics(options, bankID, log)
+	a := &Account{client: client, log: log, diag: diag, check: utils.NewChecker(options, bankID, log, diag), id: s.ID, baseUrl: s.Values["baseUrl"], form: map[string]string{}}
+	if a.fixBase = options[utils.BaseURLKey] != nil; a.fixBase || a.baseUrl == "" {
+		a.baseUrl = utils.OptionURL(options, utils.BaseURLKey, MizuhoUrl)
+	}
+	for k, v := range s.Values {
+		if strings.HasPrefix(k, "form:") {
+			a.form[strings.TrimPrefix(k, "form:")] = v
+		}
+	}
+	return a, common.SessionRejected(bankID, a.ReloadTopPage(ctx))
+}
+
+func (a *Account) SaveSession() (*common.Session, error) {
+	values := map[string]string{"baseUrl": a.baseUrl}
+	for k, v := range a.form {
+		values["form:"+k] = v
+	}
+	return &common.Session{Bank: bankID, ID: a.id, Cookies: utils.ExportCookies(a.client), Values: values, SavedAt: time.Now()}, nil
+}
+
+func (a *Account) Logout(ctx context.Context) error {
+	a.keepalive.Stop()
+	_, err := a.fetch(ctx, "MENSRV0100901B")
 	return err
 }
 
-func (a *Account) Login(id, password string, options map[string]interface{}) error {
-	_, err := a.fetch("LOGBNK0000000B")
+func (a *Account) Login(ctx context.Context, id, password string, options map[string]interface{}) error {
+	a.id = id
+	a.relogin = utils.NewRelogin(bankID, id, password, options, a.log)
+	a.log.AddSecret(id, password)
+	_, err := a.fetch(ctx, "LOGBNK0000000B")
 	if err != nil {
 		return err
 	}
-	html, err := a.execute("LOGBNK0000001B", map[string]string{
+	html, err := a.execute(ctx, "LOGBNK0000001B", map[string]string{
 		"pm_fp":     DummyFingerPrint,
 		"txbCustNo": id,
 	}, true)
@@ -68,28 +146,30 @@ func (a *Account) Login(id, password string, options map[string]interface{}) err
 	}
 
 	// aikotoba
-	qa := map[string]string{}
-	for k, v := range options {
-		qa[k] = v.(string)
+	qa := utils.SecretWords(options)
+	for _, ans := range qa {
+		a.log.AddSecret(ans, utils.ToSJIS(ans))
 	}
-	html, err = a.sendAikotoba(html, qa)
+	html, err = a.sendAikotoba(ctx, html, qa)
 	if err != nil {
 		return err
 	}
-	html, err = a.sendAikotoba(html, qa)
+	html, err = a.sendAikotoba(ctx, html, qa)
 	if err != nil {
 		return err
 	}
 
-	html, err = a.execute("LOGBNK0000501B", map[string]string{
+	page := "LOGBNK0000501B"
+	html, err = a.execute(ctx, page, map[string]string{
 		"PASSWD_LoginPwdInput": password,
 	}, true)
 	if err != nil {
 		return err
 	}
 
-	if q := getMatched(html, `<form action="" name="(LOGCNF_02400B)"`, ""); q != "" {
-		html, err = a.execute("LOGCNF0240001B", map[string]string{
+	if dom.Parse(html).Find(dom.And(dom.Tag("form"), dom.Name("LOGCNF_02400B"))) != nil {
+		page = "LOGCNF0240001B"
+		html, err = a.execute(ctx, page, map[string]string{
 			"_FORMID": "LOGCNF_02400B",
 		}, true)
 		if err != nil {
@@ -97,58 +177,91 @@ func (a *Account) Login(id, password string, options map[string]interface{}) err
 		}
 	}
 
-	return a.parseTopPage(html)
+	return a.parseTopPage(page, html)
 }
 
 func (a *Account) AccountInfo() *common.BankAccount {
 	return &a.BankAccount
 }
 
-func (a *Account) TotalBalance() (int64, error) {
+func (a *Account) TotalBalance(ctx context.Context) (int64, error) {
 	return a.balance, nil
 }
 
-func (a *Account) LastLogin() (time.Time, error) {
+func (a *Account) LastLogin(ctx context.Context) (time.Time, error) {
 	return a.lastLogin, nil
 }
 
-func (a *Account) ReloadTopPage() error {
-	html, err := a.execute("MENSRV0100001B", map[string]string{}, true)
+func (a *Account) ReloadTopPage(ctx context.Context) error {
+	html, err := a.execute(ctx, "MENSRV0100001B", map[string]string{}, true)
 	if err != nil {
 		return err
 	}
-	return a.parseTopPage(html)
+	return a.parseTopPage("MENSRV0100001B", html)
+}
+
+// StartKeepalive fetches the top page while the session is idle.
+func (a *Account) StartKeepalive(ctx context.Context, interval time.Duration, onError func(error)) {
+	a.keepalive.Start(ctx, interval, func(ctx context.Context) error {
+		_, err := a.execute(ctx, "MENSRV0100001B", map[string]string{}, true)
+		return err
+	}, onError)
+}
+
+func (a *Account) Refresh(ctx context.Context) error {
+	return a.relogin.Retry(ctx, a, func() error {
+		return a.ReloadTopPage(ctx)
+	})
+}
+
+func (a *Account) Payees(ctx context.Context) ([]*common.Payee, error) {
+	var registered map[string]string
+	err := a.relogin.Retry(ctx, a, func() (err error) {
+		registered, err = a.GetRegistered(ctx)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	var payees []*common.Payee
+	for name := range registered {
+		payees = append(payees, &common.Payee{Name: name})
+	}
+	sort.Slice(payees, func(i, j int) bool { return payees[i].Name < payees[j].Name })
+	return payees, nil
 }
 
-func (a *Account) GetRegistered() (map[string]string, error) {
-	res, err := a.execute("MENSRV0100004B", map[string]string{}, true)
+func (a *Account) GetRegistered(ctx context.Context) (map[string]string, error) {
+	res, err := a.execute(ctx, "MENSRV0100004B", map[string]string{}, true)
 	if err != nil {
 		return nil, err
 	}
 
-	re := regexp.MustCompile(`(?s)<span\s+id="txtNickNm_0*(\d+)">([^<]+)<`)
+	re := regexp.MustCompile(`^txtNickNm_0*(\d+)$`)
 	registered := map[string]string{}
-	for _, m := range re.FindAllStringSubmatch(res, -1) {
-		registered[m[2]] = m[1]
+	for _, span := range dom.Parse(res).FindAll(dom.IDPrefix("txtNickNm_")) {
+		if m := re.FindStringSubmatch(span.Attr("id")); m != nil && span.Text() != "" {
+			registered[span.Text()] = m[1]
+		}
 	}
 	return registered, nil
 }
 
-func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64) (common.TransferState, error) {
-	registered, err := a.GetRegistered()
+func (a *Account) NewTransferToRegisteredAccount(ctx context.Context, targetName string, amount int64) (*common.TransferQuote, error) {
+	registered, err := a.GetRegistered(ctx)
 	if err != nil {
 		return nil, err
 	}
 	val, ok := registered[targetName]
 	if !ok {
-		return nil, fmt.Errorf("not registered: %s in %v", targetName, registered)
+		return nil, common.NewBankError(bankID, common.ErrPayeeNotRegistered, fmt.Sprintf("%s in %v", targetName, registered))
 	}
 	acc := "0"
 	email := ""
 	message := ""
 	name := ""
 
-	_, err = a.execute("TRNTRN0500001B", map[string]string{
+	_, err = a.execute(ctx, "TRNTRN0500001B", map[string]string{
 		"lstAccLst":             acc,
 		"rdoChgOrNot":           "no", // use name?
 		"txbClntNmConfigClntNm": name,
@@ -158,7 +271,8 @@ func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64
 		return nil, err
 	}
 
-	res, err := a.execute("TRNTRN0507001B", map[string]string{
+	page := "TRNTRN0507001B"
+	res, err := a.execute(ctx, page, map[string]string{
 		"txbTrnfrAmnt":    fmt.Sprint(amount),
 		"txbRecpMailAddr": email,
 		"txaTxt":          message,
@@ -168,36 +282,57 @@ func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64
 	}
 	// log.Println(res)
 
+	doc := dom.Parse(res)
 	pp := []int{0, 0, 0, 0}
-	re := regexp.MustCompile(`<span id="txtScndPwdDgt(\d+)">(\d+)<`)
-	for _, m := range re.FindAllStringSubmatch(res, -1) {
-		i, _ := strconv.Atoi(m[1])
-		pp[i-1], _ = strconv.Atoi(m[2])
+	for _, span := range doc.FindAll(dom.IDPrefix("txtScndPwdDgt")) {
+		i, _ := strconv.Atoi(strings.TrimPrefix(span.Attr("id"), "txtScndPwdDgt"))
+		if i >= 1 && i <= len(pp) {
+			pp[i-1], _ = strconv.Atoi(span.Text())
+		}
 	}
 	if pp[0] < 1 || pp[1] < 1 || pp[2] < 1 || pp[3] < 1 {
-		return nil, fmt.Errorf("error pass2 get digits.: %v", pp)
+		return nil, a.layoutError(page, res, fmt.Sprintf("error pass2 get digits.: %v", pp))
 	}
 
-	tr := utils.TransferStateMap{
-		"pass2_digits": pp,
-		"next":         "TRNTRN0508001B",
+	check := a.check.Page(page, res)
+	if s := doc.Find(dom.ID("txtTrnfrAmnt")).Text(); s == "" {
+		check.Fatal("txtTrnfrAmnt", "")
+	} else if confirmed, err := utils.ParseAmount(s); err != nil || confirmed != amount {
+		check.Fatal("txtTrnfrAmnt", s)
 	}
-
-	tr["fee"] = getMatched(res, `<span\s+id="txtTrnfrFee"[^>]*>([\d,]+)`, "")
-	tr["amount"] = getMatched(res, `<span\s+id="txtTrnfrAmnt"[^>]*>([\d,]+)`, "")
-	tr["date"] = getMatched(res, `<span\s+id="txtTrnfrAppDate"[^>]*>([^<]+)<`, "")
-	tr["payee"] = getMatched(res, `<span\s+id="txtPayeeNm"[^>]*>([^<]+)<`, "")
-
-	return tr, nil
+	s := doc.Find(dom.ID("txtTrnfrFee")).Text()
+	fee, err := utils.ParseAmount(s)
+	if err != nil {
+		check.Invalid("txtTrnfrFee", s)
+	}
+	s = doc.Find(dom.ID("txtTrnfrAppDate")).Text()
+	date, err := utils.ParseDate(s)
+	if err != nil {
+		check.Invalid("txtTrnfrAppDate", s)
+	}
+	if err := check.Err(); err != nil {
+		return nil, err
+	}
+	return &common.TransferQuote{
+		Amount:        common.Yen(amount),
+		Fee:           common.Yen(fee),
+		Total:         common.Yen(amount + fee),
+		ScheduledDate: date,
+		PayeeName:     doc.Find(dom.ID("txtPayeeNm")).Text(),
+		PayeeBank:     doc.Find(dom.ID("txtBnkNm")).Text(),
+		PayeeBranch:   doc.Find(dom.ID("txtBrnchNm")).Text(),
+		PayeeAccount:  doc.Find(dom.ID("txtAccNo")).Text(),
+		State:         &transferState{pass2Digits: pp, next: "TRNTRN0508001B"},
+	}, nil
 }
 
-func (a *Account) CommitTransfer(tr common.TransferState, pass2 string) (string, error) {
-	tr1, ok := tr.(utils.TransferStateMap)
+func (a *Account) CommitTransfer(ctx context.Context, tr *common.TransferQuote, pass2 string) (string, error) {
+	st, ok := tr.State.(*transferState)
 	if !ok {
 		return "", errors.New("invalid paramter type: tr")
 	}
-	pp := tr1["pass2_digits"].([]int)
-	res, err := a.execute("TRNTRN0508001B", map[string]string{
+	pp := st.pass2Digits
+	res, err := a.execute(ctx, st.next, map[string]string{
 		"PASSWD_ScndPwd1":   string(pass2[pp[0]-1]),
 		"PASSWD_ScndPwd2":   string(pass2[pp[1]-1]),
 		"PASSWD_ScndPwd3":   string(pass2[pp[2]-1]),
@@ -205,103 +340,173 @@ func (a *Account) CommitTransfer(tr common.TransferState, pass2 string) (string,
 		"chkTrnfrCntntConf": "on",
 	}, true)
 
-	return getMatched(res, `<span\s+id="txtRecptNo"[^>]*>([^<]+)`, ""), err
+	return dom.Parse(res).Find(dom.ID("txtRecptNo")).Text(), err
 }
 
-func (a *Account) parseTopPage(doc string) error {
-
-	if m := getMatched(doc, `<span\s+id="txtCrntBal"[^>]*>([\d,]+)`, ""); m != "" {
-		a.balance, _ = strconv.ParseInt(strings.Replace(m, ",", "", -1), 10, 64)
-	}
-	a.recent = a.parseHistory(doc, a.balance)
-
+func (a *Account) parseTopPage(page, res string) error {
+	doc := dom.Parse(res)
+	check := a.check.Page(page, res)
 	a.BankCode = BankCode
 	a.BankName = BankName
-	a.OwnerName = getMatched(doc, `<span\s+id="txtLoginInfoCustNm"[^>]*>([^<]+)`, "")
-	a.BranchCode = getMatched(doc, `<meta property="page.branchcd" content="(\d+)">`, "")
-	a.BranchName = getMatched(doc, `<span\s+id="txtBrnch"[^>]*>([^<]+)`, "")
-	a.AccountNum = getMatched(doc, `<span\s+id="txtAccNo"[^>]*>([^<]+)`, "")
+	a.OwnerName = doc.Find(dom.ID("txtLoginInfoCustNm")).Text()
+	a.BranchCode = doc.Find(dom.And(dom.Tag("meta"), dom.Attr("property", "page.branchcd"))).Attr("content")
+	a.BranchName = doc.Find(dom.ID("txtBrnch")).Text()
+	a.AccountNum = doc.Find(dom.ID("txtAccNo")).Text()
+	a.log.AddSecret(a.AccountNum, a.OwnerName)
+	if a.AccountNum == "" {
+		check.Missing("txtAccNo")
+	}
 
-	if m := getMatched(doc, `<span\s+id="txtLastUsgTm"[^>]*>([^<]+)`, ""); m != "" {
-		m = strings.Replace(m, "\uC2A0", " ", -1)
+	if m := doc.Find(dom.ID("txtLastUsgTm")).Text(); m != "" {
+		m = strings.NewReplacer("\uC2A0", " ", "\u00A0", " ").Replace(m)
 		var timeformat = "2006.01.02 15:04"
 		if t, err := time.Parse(timeformat, m); err == nil {
 			a.lastLogin = t
+		} else {
+			check.Invalid("txtLastUsgTm", m)
 		}
 	}
-	return nil
+
+	m := doc.Find(dom.ID("txtCrntBal")).Text()
+	balance, err := utils.ParseAmount(m)
+	if err != nil {
+		check.Invalid("txtCrntBal", m)
+	}
+	a.balance = balance
+	a.recent = a.parseHistory(doc, a.balance, check)
+	return check.Err()
 }
 
-func (a *Account) sendAikotoba(html string, qa map[string]string) (string, error) {
-	if q := getMatched(html, `<span id="txtQuery">([^<]+)`, ""); q != "" {
+func (a *Account) sendAikotoba(ctx context.Context, html string, qa map[string]string) (string, error) {
+	if q := dom.Parse(html).Find(dom.ID("txtQuery")).Text(); q != "" {
 		var ans string
 		for k, v := range qa {
 			if strings.Contains(q, k) {
 				ans = v
 			}
 		}
-		log.Println(q, ans != "")
+		a.log.Debug("aikotoba", "question", q, "answered", ans != "")
 		if ans == "" {
 			return "", nil
 		}
-		return a.execute("LOGWRD0010001B", map[string]string{
+		return a.execute(ctx, "LOGWRD0010001B", map[string]string{
 			"chkConfItemChk": "on",
-			"txbTestWord":    utils.ToSJIS(ans),
+			"txbTestWord":    ans,
 		}, true)
 	}
 	return html, nil
 }
 
-func (a *Account) parseHistory(doc string, balance int64) []*common.Transaction {
-	re := regexp.MustCompile(`(?s)<span\s+id="txtDate_.*?</tr>`)
-	dateRe := regexp.MustCompile(`(?s)<span\s+id="txtDate_\d+">([^<]*)<`)
-	descRe := regexp.MustCompile(`(?s)<span\s+id="txtTransCntnt_\d+">([^<]*)`)
-	amountRe := regexp.MustCompile(`(?s)<span\s+id="txtDrawAmnt_\d+">([\d,]+)`)
-	damountRe := regexp.MustCompile(`(?s)<span\s+id="txtDpstAmnt_\d+">([\d,]+)`)
+func (a *Account) parseHistory(doc *dom.Node, balance int64, check *utils.PageCheck) []*common.Transaction {
+	idRe := regexp.MustCompile(`^(\w+?)_\d+$`)
 	trs := []*common.Transaction{}
 
-	for _, s := range re.FindAllString(doc, -1) {
+	for _, date := range doc.FindAll(dom.IDPrefix("txtDate_")) {
+		row := date.Closest(dom.Tag("tr"))
+		if row == nil {
+			continue
+		}
 		var tr common.Transaction
-		if match := dateRe.FindStringSubmatch(s); match != nil {
-			var timeformat = "2006.01.02"
-			if t, err := time.Parse(timeformat, html.UnescapeString(match[1])); err == nil {
-				tr.Date = t
+		tr.Raw = map[string]string{}
+		for _, span := range row.FindAll(dom.Tag("span")) {
+			if m := idRe.FindStringSubmatch(span.Attr("id")); m != nil {
+				tr.Raw[m[1]] = span.Text()
 			}
 		}
-		if match := descRe.FindStringSubmatch(s); match != nil {
-			tr.Description = match[1]
+		var timeformat = "2006.01.02"
+		if t, err := time.Parse(timeformat, tr.Raw["txtDate"]); err == nil {
+			tr.Date = t
+		} else {
+			check.Invalid("txtDate", tr.Raw["txtDate"])
+		}
+		tr.Description = tr.Raw["txtTransCntnt"]
+		tr.Kind, tr.Counterparty = common.ParseDescription(tr.Description)
+		draw, dpst := tr.Raw["txtDrawAmnt"], tr.Raw["txtDpstAmnt"]
+		if am, err := utils.ParseAmount(draw); err == nil {
+			tr.Amount = common.Yen(-am)
+		} else if draw != "" {
+			check.Invalid("txtDrawAmnt", draw)
 		}
-		if match := amountRe.FindStringSubmatch(s); match != nil {
-			am, _ := strconv.ParseInt(strings.Replace(match[1], ",", "", -1), 10, 64)
-			tr.Amount = -am
+		if am, err := utils.ParseAmount(dpst); err == nil {
+			tr.Amount = common.Yen(am)
+		} else if dpst != "" {
+			check.Invalid("txtDpstAmnt", dpst)
 		}
-		if match := damountRe.FindStringSubmatch(s); match != nil {
-			am, _ := strconv.ParseInt(strings.Replace(match[1], ",", "", -1), 10, 64)
-			tr.Amount = am
+		if draw == "" && dpst == "" {
+			check.Missing("txtDrawAmnt/txtDpstAmnt")
 		}
 		trs = append(trs, &tr)
 	}
 	if balance >= 0 {
 		for i := len(trs) - 1; i >= 0; i-- {
-			trs[i].Balance = balance
-			balance -= trs[i].Amount
+			trs[i].Balance = common.Yen(balance)
+			balance -= trs[i].Amount.Value
 		}
 	}
 	return trs
 }
 
-func (a *Account) Recent() ([]*common.Transaction, error) {
+func (a *Account) Recent(ctx context.Context) ([]*common.Transaction, error) {
 	return a.recent, nil
 }
 
-func (a *Account) History(from, to time.Time) ([]*common.Transaction, error) {
-	acc := "0"
-	mode := "2"
-	_, err := a.execute("MENSRV0100003B", map[string]string{}, true)
+// 取得可能な履歴は過去3ヶ月
+var historyLimits = common.HistoryLimits{MaxMonths: 3}
+
+func (a *Account) HistoryLimits() common.HistoryLimits {
+	return historyLimits
+}
+
+func (a *Account) History(ctx context.Context, from, to time.Time) ([]*common.Transaction, error) {
+	return a.history(ctx, "0", from, to)
+}
+
+func (a *Account) history(ctx context.Context, acc string, from, to time.Time) ([]*common.Transaction, error) {
+	return common.FetchHistory(ctx, historyLimits, from, to, func(ctx context.Context, from, to time.Time) (trs []*common.Transaction, err error) {
+		err = a.relogin.Retry(ctx, a, func() (err error) {
+			trs, err = a.fetchHistory(ctx, acc, from, to)
+			return
+		})
+		return
+	})
+}
+
+func (a *Account) fetchHistory(ctx context.Context, acc string, from, to time.Time) ([]*common.Transaction, error) {
+	page := "ACCHST0400001B"
+	res, err := a.historyPage(ctx, acc, from, to)
 	if err != nil {
 		return nil, err
 	}
-	res, err := a.execute("ACCHST0400001B", map[string]string{
+	check := a.check.Page(page, res)
+	trs := a.parseHistory(dom.Parse(res), -1, check)
+	return trs, check.Err()
+}
+
+// fetchBalance returns the current balance of the account shown in the history page.
+func (a *Account) fetchBalance(ctx context.Context, acc string) (int64, error) {
+	page := "ACCHST0400001B"
+	today := time.Now()
+	res, err := a.historyPage(ctx, acc, today, today)
+	if err != nil {
+		return 0, err
+	}
+	check := a.check.Page(page, res)
+	s := dom.Parse(res).Find(dom.ID("txtCrntBal")).Text()
+	balance, err := utils.ParseAmount(s)
+	if err != nil {
+		check.Invalid("txtCrntBal", s)
+	}
+	return balance, check.Err()
+}
+
+// historyPage returns the history inquiry result of the account (index of lstAccSel).
+func (a *Account) historyPage(ctx context.Context, acc string, from, to time.Time) (string, error) {
+	mode := "2"
+	_, err := a.execute(ctx, "MENSRV0100003B", map[string]string{}, true)
+	if err != nil {
+		return "", err
+	}
+	return a.execute(ctx, "ACCHST0400001B", map[string]string{
 		"lstAccSel":        acc,
 		"rdoInqMtdSpec":    mode,
 		"lstTargetMnthSel": "NO_WRITE", // (THIS_MONTH,PREV_MONTH,BEFORE_LASTMONTH,NO_WRITE)
@@ -312,12 +517,77 @@ func (a *Account) History(from, to time.Time) ([]*common.Transaction, error) {
 		"lstDateToMnth":    fmt.Sprint(int(to.Month())),
 		"lstDateToDay":     fmt.Sprint(to.Day()),
 	}, true)
+}
+
+// Accounts returns accounts listed in the history inquiry page (lstAccSel).
+func (a *Account) Accounts(ctx context.Context) ([]common.SubAccount, error) {
+	var res string
+	err := a.relogin.Retry(ctx, a, func() (err error) {
+		res, err = a.execute(ctx, "MENSRV0100003B", map[string]string{}, true)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.parseAccounts(res), nil
+}
+
+func (a *Account) parseAccounts(res string) []common.SubAccount {
+	accounts := []common.SubAccount{}
+	sel := dom.Parse(res).Find(dom.And(dom.Tag("select"), dom.Name("lstAccSel")))
+	for _, opt := range sel.FindAll(dom.Tag("option")) {
+		index := opt.Attr("value")
+		if _, err := strconv.Atoi(index); err != nil {
+			continue
+		}
+		sub := &subAccount{a: a, index: index, BankAccount: a.BankAccount}
+		// e.g. "本店 普通 1234567"
+		if f := strings.Fields(opt.Text()); len(f) >= 2 {
+			sub.BranchName = f[0]
+			sub.AccountNum = f[len(f)-1]
+		}
+		accounts = append(accounts, sub)
+	}
+	if len(accounts) == 0 {
+		accounts = append(accounts, &subAccount{a: a, index: "0", BankAccount: a.BankAccount})
+	}
+	return accounts
+}
+
+type subAccount struct {
+	common.BankAccount
+	a     *Account
+	index string
+}
+
+func (s *subAccount) AccountInfo() *common.BankAccount {
+	return &s.BankAccount
+}
 
-	return a.parseHistory(res, -1), err
+// Balance returns the balance of the account. Balances other than the main account are read from the history page.
+func (s *subAccount) Balance(ctx context.Context) (common.Money, error) {
+	if s.index == "0" {
+		return common.Yen(s.a.balance), nil
+	}
+	var balance int64
+	err := s.a.relogin.Retry(ctx, s.a, func() (err error) {
+		balance, err = s.a.fetchBalance(ctx, s.index)
+		return
+	})
+	if err != nil {
+		return common.Money{}, err
+	}
+	return common.Yen(balance), nil
 }
 
-func (a *Account) execute(pageId string, params map[string]string, check bool) (string, error) {
+func (s *subAccount) History(ctx context.Context, from, to time.Time) ([]*common.Transaction, error) {
+	return s.a.history(ctx, s.index, from, to)
+}
+
+func (a *Account) execute(ctx context.Context, pageId string, params map[string]string, check bool) (string, error) {
 	// log.Println("execute ", pageId, params)
+	a.keepalive.Lock()
+	defer a.keepalive.Unlock()
 
 	values := url.Values{}
 	for k, v := range a.form {
@@ -327,7 +597,11 @@ func (a *Account) execute(pageId string, params map[string]string, check bool) (
 		values.Set(k, v)
 	}
 
-	req, err := http.NewRequest("POST", a.baseUrl+pageId+".do", strings.NewReader(values.Encode()))
+	body, err := utils.EncodeForm(values, a.charset)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseUrl+pageId+".do", strings.NewReader(body))
 	if err != nil {
 		return "", err
 	}
@@ -336,14 +610,19 @@ func (a *Account) execute(pageId string, params map[string]string, check bool) (
 	return a.request(req)
 }
 
-func (a *Account) fetch(pageId string) (string, error) {
+func (a *Account) fetch(ctx context.Context, pageId string) (string, error) {
 	// log.Println("fetch ", pageId)
+	a.keepalive.Lock()
+	defer a.keepalive.Unlock()
 	values := url.Values{}
 	for k, v := range a.form {
 		values.Set(k, v)
 	}
-	url := a.baseUrl + pageId + ".do?" + values.Encode()
-	req, err := http.NewRequest("GET", url, nil)
+	query, err := utils.EncodeForm(values, a.charset)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", a.baseUrl+pageId+".do?"+query, nil)
 	if err != nil {
 		return "", err
 	}
@@ -357,34 +636,40 @@ func (a *Account) request(req *http.Request) (string, error) {
 	}
 	defer res.Body.Close()
 
-	b, err := ioutil.ReadAll(transform.NewReader(res.Body, japanese.ShiftJIS.NewDecoder()))
+	html, cs, err := utils.ReadBody(res)
 	if err != nil {
 		return "", err
 	}
-	html := string(b)
-	a.baseUrl = getMatched(html, `<base href="(https://[^"]+)">`, a.baseUrl)
+	doc := dom.Parse(html)
+	a.charset = utils.FormCharset(doc, cs)
+	if base := doc.Find(dom.Tag("base")).Attr("href"); strings.HasPrefix(base, "https://") && !a.fixBase {
+		a.baseUrl = base
+	}
 
-	form := map[string]string{
-		"_FRAMEID":  getFormValue(html, "_FRAMEID"),
-		"_TARGETID": getFormValue(html, "_TARGETID"),
-		"_LUID":     getFormValue(html, "_LUID"),     // ?
-		"_SUBINDEX": getFormValue(html, "_SUBINDEX"), // ?
-		"_TOKEN":    getFormValue(html, "_TOKEN"),
-		"_FORMID":   getFormValue(html, "_FORMID"),
-		"POSTKEY":   getFormValue(html, "POSTKEY"),
+	form := map[string]string{}
+	for _, name := range formKeys {
+		form[name] = doc.InputValue(name)
 	}
 	if form["POSTKEY"] == "" {
-		msg := getMatched(html, `<div\s[^>]*id="ErrorMessage"[^>]*>(.+?)</"`, "")
-		return html, fmt.Errorf("execute error" + msg)
+		msg := doc.Find(dom.ID("ErrorMessage")).Text()
+		if msg == "" && doc.Find(dom.Name("txbCustNo")) != nil {
+			// redirected to the login page.
+			return html, common.NewBankError(bankID, common.ErrSessionExpired, "login page returned")
+		}
+		return html, a.diag.Snapshot(newError(msg), strings.TrimSuffix(path.Base(req.URL.Path), ".do"), html)
 	}
 	a.form = form
 	return html, nil
 }
 
-func getFormValue(html, name string) string {
-	return getMatched(html, `<input\s[^>]*?name="`+name+`"[^>]*?value="([^"]*)"`, "")
+// layoutError returns an ErrLayoutChanged error with the snapshot of the page.
+func (a *Account) layoutError(page, doc, msg string) error {
+	return a.diag.Snapshot(common.NewBankError(bankID, common.ErrLayoutChanged, msg), page, doc)
 }
 
-func getMatched(htmlStr, reStr, def string) string {
-	return utils.GetMatched(htmlStr, reStr, def)
+func newError(msg string) error {
+	if msg == "" {
+		return common.NewBankError(bankID, common.ErrLayoutChanged, "POSTKEY not found")
+	}
+	return common.NewBankError(bankID, common.ClassifyMessage(msg), msg)
 }
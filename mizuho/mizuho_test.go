@@ -0,0 +1,349 @@
+package mizuho
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binzume/gobanking/common"
+	"github.com/binzume/gobanking/utils"
+)
+
+func login(s *fakeServer, password string, options map[string]interface{}) (*Account, error) {
+	opts := map[string]interface{}{
+		"母親の旧姓": testAnswer,
+	}
+	for k, v := range s.options() {
+		opts[k] = v
+	}
+	for k, v := range options {
+		opts[k] = v
+	}
+	return LoginContext(context.Background(), testID, password, opts)
+}
+
+func TestLogin(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	info := acc.AccountInfo()
+	if info.AccountNum != "1234567" || info.BranchCode != "123" || info.BranchName != "本店" || info.OwnerName != "みずほ　太郎" {
+		t.Errorf("unexpected account info: %+v", info)
+	}
+	if balance, _ := acc.TotalBalance(context.Background()); balance != 1234567 {
+		t.Errorf("unexpected balance: %v", balance)
+	}
+	if last, _ := acc.LastLogin(context.Background()); !last.Equal(time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)) {
+		t.Errorf("unexpected last login: %v", last)
+	}
+	recent, _ := acc.Recent(context.Background())
+	if len(recent) != 2 || recent[0].Amount != common.Yen(-10000) || recent[1].Balance != common.Yen(1234567) || recent[1].Kind != common.KindTransfer {
+		t.Errorf("unexpected recent: %v", recent)
+	}
+
+	if err := acc.Logout(context.Background()); err != nil {
+		t.Errorf("logout failed: %v", err)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	s := newFakeServer(t)
+	_, err := login(s, "wrong", nil)
+	if !errors.Is(err, common.ErrLoginRejected) {
+		t.Errorf("expected ErrLoginRejected: %v", err)
+	}
+}
+
+func TestBaseHref(t *testing.T) {
+	s := newFakeServer(t)
+	s.base = "https://web.ib.mizuhobank.invalid/servlet/"
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	// <base href> is ignored if base_url is specified.
+	if _, err := acc.Recent(context.Background()); err != nil {
+		t.Errorf("recent failed: %v", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+	from := time.Now().AddDate(0, 0, -10)
+	trs, err := acc.History(ctx, from, time.Now())
+	if err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	if len(trs) != 2 || trs[0].Date.Day() != from.Day() || trs[1].Amount != common.Yen(20000) || trs[1].Counterparty != "ヤマダ ハナコ" {
+		t.Errorf("unexpected history: %v", trs)
+	}
+
+	accounts, err := acc.Accounts(ctx)
+	if err != nil || len(accounts) != 2 || accounts[1].AccountInfo().BranchName != "渋谷支店" {
+		t.Fatalf("unexpected accounts: %v %v", accounts, err)
+	}
+	for i, expected := range []int64{1234567, 300000} {
+		if balance, err := accounts[i].Balance(ctx); err != nil || balance != common.Yen(expected) {
+			t.Errorf("unexpected balance of accounts[%d]: %v %v", i, balance, err)
+		}
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+
+	payees, err := acc.Payees(ctx)
+	if err != nil || len(payees) != 2 {
+		t.Fatalf("unexpected payees: %v %v", payees, err)
+	}
+	if _, err := acc.NewTransferToRegisteredAccount(ctx, "unknown", 1000); !errors.Is(err, common.ErrPayeeNotRegistered) {
+		t.Errorf("expected ErrPayeeNotRegistered: %v", err)
+	}
+	if _, err := acc.NewTransferToRegisteredAccount(ctx, testPayee, 2000000); !errors.Is(err, common.ErrInsufficientFunds) {
+		t.Errorf("expected ErrInsufficientFunds: %v", err)
+	}
+
+	tr, err := acc.NewTransferToRegisteredAccount(ctx, testPayee, 1000)
+	if err != nil {
+		t.Fatalf("failed to create transfer: %v", err)
+	}
+	if tr.Amount != common.Yen(1000) || tr.Fee != common.Yen(220) || tr.Total != common.Yen(1220) || tr.ScheduledDate.Day() != 10 {
+		t.Errorf("unexpected quote: %v", tr)
+	}
+	if tr.PayeeBank != "テスト銀行" || tr.PayeeBranch != "本店" || tr.PayeeAccount != "1111111" || tr.PayeeName != "ﾃｽﾄ ﾀﾛｳ" {
+		t.Errorf("unexpected payee: %v", tr)
+	}
+	recptNo, err := acc.CommitTransfer(ctx, tr, testPass2)
+	if err != nil || recptNo != "R0001" {
+		t.Fatalf("failed to commit: %v %v", recptNo, err)
+	}
+	if len(s.committed) != 1 || s.committed[0] != 1000 {
+		t.Errorf("unexpected transfers: %v", s.committed)
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	s.expire()
+	if err := acc.Refresh(context.Background()); !errors.Is(err, common.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired: %v", err)
+	}
+
+	acc, err = login(s, testPassword, map[string]interface{}{"relogin": true})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	s.expire()
+	if _, err := acc.Payees(context.Background()); err != nil {
+		t.Errorf("relogin failed: %v", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	s := newFakeServer(t)
+	s.redesigned = true
+	dir := t.TempDir()
+	_, err := login(s, testPassword, map[string]interface{}{utils.DiagnosticsDirKey: dir})
+	var layoutErr *common.LayoutError
+	if !errors.Is(err, common.ErrLayoutChanged) || !errors.As(err, &layoutErr) {
+		t.Fatalf("expected ErrLayoutChanged: %v", err)
+	}
+	if filepath.Dir(layoutErr.Snapshot) != dir || !strings.HasPrefix(filepath.Base(layoutErr.Snapshot), "mizuho_LOGBNK0000501B_") {
+		t.Fatalf("unexpected snapshot: %v", layoutErr.Snapshot)
+	}
+	if !strings.Contains(err.Error(), layoutErr.Snapshot) {
+		t.Errorf("snapshot is not in the message: %v", err)
+	}
+	b, err := ioutil.ReadFile(layoutErr.Snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := string(b)
+	if !strings.Contains(doc, "txtBalance") {
+		t.Errorf("unexpected snapshot: %s", doc)
+	}
+	for _, secret := range []string{testID, "1234567", "KEY0", `value="TOKEN`} {
+		if strings.Contains(doc, secret) {
+			t.Errorf("%q is not masked: %s", secret, doc)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	s := newFakeServer(t)
+	s.redesigned = true
+	_, err := login(s, testPassword, nil)
+	var layoutErr *common.LayoutError
+	if !errors.As(err, &layoutErr) || layoutErr.Field != "txtCrntBal" || layoutErr.Page != "LOGBNK0000501B" {
+		t.Fatalf("expected LayoutError: %v", err)
+	}
+
+	acc, err := login(s, testPassword, map[string]interface{}{utils.StrictKey: false})
+	if err != nil {
+		t.Fatalf("lenient login failed: %v", err)
+	}
+	if balance, _ := acc.TotalBalance(context.Background()); balance != 0 {
+		t.Errorf("unexpected balance: %v", balance)
+	}
+
+	// compatible
+	opts := s.options()
+	opts["母親の旧姓"] = testAnswer
+	if _, err := Login(testID, testPassword, opts); err != nil {
+		t.Errorf("Login without context must be lenient: %v", err)
+	}
+}
+
+func TestLenientRows(t *testing.T) {
+	s := newFakeServer(t)
+	// the amount of the second row is unreadable.
+	s.history = historyRow(1, "2020.01.01", "ATM", -10000) + strings.Replace(historyRow(2, "2020.01.02", "利息", 5), "5円", "＊＊＊", 1)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+	_, err = acc.History(ctx, time.Now().AddDate(0, 0, -10), time.Now())
+	var layoutErr *common.LayoutError
+	if !errors.As(err, &layoutErr) || layoutErr.Field != "txtDpstAmnt" {
+		t.Fatalf("expected LayoutError: %v", err)
+	}
+
+	// lenient mode keeps the row with zero amount.
+	acc, err = login(s, testPassword, map[string]interface{}{utils.StrictKey: false})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	trs, err := acc.History(ctx, time.Now().AddDate(0, 0, -10), time.Now())
+	if err != nil || len(trs) != 2 || trs[1].Description != "利息" || trs[1].Amount.Value != 0 || trs[1].Raw["txtDpstAmnt"] != "＊＊＊" {
+		t.Errorf("unexpected history: %v %v", trs, err)
+	}
+}
+
+func TestCharset(t *testing.T) {
+	s := newFakeServer(t)
+	s.utf8 = true
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if info := acc.AccountInfo(); info.OwnerName != "みずほ　太郎" || info.BranchName != "本店" {
+		t.Errorf("unexpected account info: %+v", info)
+	}
+	if acc.charset != "utf-8" {
+		t.Errorf("unexpected form charset: %v", acc.charset)
+	}
+	trs, err := acc.History(context.Background(), time.Now().AddDate(0, 0, -10), time.Now())
+	if err != nil || len(trs) != 2 || trs[1].Counterparty != "ヤマダ ハナコ" {
+		t.Errorf("unexpected history: %v %v", trs, err)
+	}
+}
+
+func TestMergeRecentAndHistory(t *testing.T) {
+	s := newFakeServer(t)
+	// the history page has no balance. (dates are not checked by the fake server)
+	s.history = historyRow(1, "2019.12.30", "利息", 5) + historyRow(2, "2020.01.01", "ATM", -10000) + historyRow(3, "2020.01.02", "振込 ミズホ　ジロウ", 50000)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+	recent, _ := acc.Recent(ctx)
+	history, err := acc.History(ctx, time.Now().AddDate(0, 0, -10), time.Now())
+	if err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	merged := common.MergeTransactions(recent, history)
+	if len(merged) != 3 || merged[0].Description != "利息" || merged[2].Balance != common.Yen(1234567) {
+		t.Errorf("unexpected merged: %v", merged)
+	}
+
+	// the same rows have the same fingerprints with or without balance.
+	fh := map[string]bool{}
+	for _, f := range common.Fingerprints(history) {
+		fh[f] = true
+	}
+	for i, f := range common.Fingerprints(recent) {
+		if !fh[f] {
+			t.Errorf("fingerprint of %v not found in history", recent[i])
+		}
+	}
+}
+
+func TestTransferQuoteChanged(t *testing.T) {
+	s := newFakeServer(t)
+	s.badQuote = true
+	// the amount is checked even in lenient mode.
+	for _, strict := range []bool{true, false} {
+		acc, err := login(s, testPassword, map[string]interface{}{utils.StrictKey: strict})
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		tr, err := acc.NewTransferToRegisteredAccount(context.Background(), testPayee, 1000)
+		var layoutErr *common.LayoutError
+		if tr != nil || !errors.As(err, &layoutErr) || layoutErr.Field != "txtTrnfrAmnt" {
+			t.Errorf("strict=%v: expected LayoutError: %v %v", strict, tr, err)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+	saved, err := acc.SaveSession()
+	if err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	// sessions are stored as JSON.
+	b, _ := json.Marshal(saved)
+	var session common.Session
+	if err := json.Unmarshal(b, &session); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := Resume(ctx, &session, s.options())
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	if resumed.AccountInfo().AccountNum != acc.AccountInfo().AccountNum {
+		t.Errorf("unexpected account: %+v", resumed.AccountInfo())
+	}
+	if balance, _ := resumed.TotalBalance(ctx); balance != 1234567 {
+		t.Errorf("unexpected balance: %v", balance)
+	}
+
+	// banking.Resume logs in again if the session is expired.
+	s.expire()
+	if _, err := Resume(ctx, &session, s.options()); !errors.Is(err, common.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired: %v", err)
+	}
+	if _, err := login(s, testPassword, nil); err != nil {
+		t.Errorf("login failed: %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package rakuten
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/binzume/gobanking/utils"
+)
+
+const (
+	testID       = "rakuten-user"
+	testPassword = "password"
+	testQuestion = "出身地は？"
+	testAnswer   = "とうきょう"
+	testPass2    = "1234"
+	testPayee    = "テスト太郎"
+)
+
+// fakeServer emulates Rakuten Bank. Pages are Shift_JIS JSF pages with javax.faces.ViewState.
+type fakeServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu        sync.Mutex
+	seq       int
+	viewState string
+	loggedIn  bool
+	secret    bool // secret word is required
+	token     string
+	amount    string
+	committed []string // committed transfer amounts
+
+	shortRow bool // the recent transactions have a row without balance
+	badQuote bool // the transfer confirmation page shows a different amount
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// options returns login options to use the fake server.
+func (s *fakeServer) options() map[string]interface{} {
+	return map[string]interface{}{utils.BaseURLKey: s.URL + "/XMS/"}
+}
+
+// expire invalidates the current session.
+func (s *fakeServer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loggedIn = false
+}
+
+const (
+	pathLogin    = "/XMS/security/fcs/rb/fes/views/mainservice/Security/LoginAuthentication/Login/Login.xhtml"
+	pathSecret   = "/XMS/commonservice/Security/LoginAuthentication/SecretWordAuthentication/SecretWordAuthentication.xhtml"
+	pathHistory  = "/XMS/mainservice/Inquiry/CreditDebitInquiry/CreditDebitInquiry/CreditDebitInquiry.xhtml"
+	pathMenu     = "/XMS/mainservice/Transfer/TransferMenu/TransferMenu/TransferMenu.xhtml"
+	pathSelect   = "/XMS/mainservice/Transfer/TransferMenu/TransferSelect/TransferSelect.xhtml"
+	pathInput    = "/XMS/mainservice/Transfer/TransferInput/TransferInput/TransferInput.xhtml"
+	pathConfirm  = "/XMS/mainservice/Transfer/TransferConfirm/TransferConfirm/TransferConfirm.xhtml"
+	loginPageTag = `<form id="LOGIN" name="LOGIN"><input type="text" name="LOGIN:USER_ID" value=""><input type="password" name="LOGIN:LOGIN_PASSWORD" value=""></form>`
+)
+
+func (s *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ParseForm()
+
+	if r.Method == "POST" && r.FormValue("javax.faces.ViewState") != s.viewState {
+		s.write(w, `<div class="errortxt">セッションがタイムアウトしました。再度ログインしてください。</div>`)
+		return
+	}
+	path := r.URL.Path
+	command := r.URL.Query().Get("COMMAND")
+	switch {
+	case path == "/MS/main/RbS" && command == "LOGIN":
+		s.loggedIn = false
+		s.write(w, loginPageTag)
+	case path == pathLogin:
+		if r.FormValue("LOGIN:USER_ID") != testID || r.FormValue("LOGIN:LOGIN_PASSWORD") != testPassword {
+			s.write(w, `<div class="errortxt">ユーザIDまたはログインパスワードが違います。</div>`+loginPageTag)
+			return
+		}
+		s.secret = true
+		s.token = fmt.Sprintf("T%d", s.seq)
+		s.write(w, `<table><tr><th>質問</th><td> `+testQuestion+` </td></tr></table>
+<input type="hidden" name="INPUT_FORM:TOKEN" value="`+s.token+`"><input type="password" name="INPUT_FORM:SECRET_WORD" value="">`)
+	case path == pathSecret:
+		if !s.secret || r.FormValue("INPUT_FORM:TOKEN") != s.token || r.FormValue("INPUT_FORM:SECRET_WORD") != utils.ToSJIS(testAnswer) {
+			s.write(w, `<div class="errortxt">認証に失敗しました。</div>`)
+			return
+		}
+		s.secret = false
+		s.loggedIn = true
+		s.write(w, `ようこそ`)
+	case path == "/XMS/gns" && command == "LOGOUT_START":
+		s.loggedIn = false
+		s.write(w, `ログアウトしました`)
+	case !s.loggedIn:
+		s.write(w, `<div>ログインしてください</div>`+loginPageTag)
+	default:
+		s.handleMenu(w, r, path, command)
+	}
+}
+
+func (s *fakeServer) handleMenu(w http.ResponseWriter, r *http.Request, path, command string) {
+	switch {
+	case path == "/XMS/inquiry/gns" && command == "BALANCE_INQUIRY_START":
+		s.write(w, `<span class="smediumbold marginright4">楽天　太郎</span>様
+<span class="login-date02">2020/01/02 03:04:05</span>
+<span class="branch-name">ロック支店</span>
+<span class="branch-number"><span>支店番号</span><span>201</span></span>
+<span class="account-number"><span>口座番号</span><span>7654321</span></span>
+<table><tr><th>総額（評価額）</th><td><span class="amount">1,000,000</span>円</td></tr></table>`)
+	case path == "/XMS/inquiry/gns" && command == "CREDIT_DEBIT_INQUIRY_START":
+		short := ""
+		if s.shortRow {
+			short = `<tr class="td01line"><td class="center"><div>2019/12/31</div></td><td><div>ATM</div></td><td class="right"><div>-1,000</div></td></tr>`
+		}
+		// newest first
+		s.write(w, `<table>
+<tr class="td01line"><td class="center"><div>2020/01/03</div></td><td><div>振込 ラクテン　ハナコ</div></td><td class="right"><div><span class="minus">-3,000</span></div></td><td class="right"><div>1,000,000</div></td></tr>
+<tr class="td02line"><td class="center"><div>2020/01/01</div></td><td><div>給与</div></td><td class="right"><div><span>503,000</span></div></td><td class="right"><div>1,003,000</div></td></tr>`+short+`
+</table>`)
+	case path == pathHistory:
+		if r.FormValue("FORM_DOWNLOAD:EXPECTED_DATE_FROM_YEAR") == "" || r.FormValue("FORM_DOWNLOAD:DOWNLOAD_TYPE") != "0" {
+			s.write(w, `<div class="errortxt">期間を指定してください。</div>`)
+			return
+		}
+		from := r.FormValue("FORM_DOWNLOAD:EXPECTED_DATE_FROM_YEAR") + r.FormValue("FORM_DOWNLOAD:EXPECTED_DATE_FROM_MONTH") + r.FormValue("FORM_DOWNLOAD:EXPECTED_DATE_FROM_DAY")
+		w.Header().Set("Content-Type", "text/csv; charset=Shift_JIS")
+		fmt.Fprint(w, utils.ToSJIS("取引日,入出金(円),取引後残高(円),入出金内容\r\n"+
+			from+",503000,1003000,給与\r\n"+
+			from+",-3000,1000000,振込 ラクテン　ハナコ\r\n"))
+	case path == "/XMS/gns" && command == "TRANSFER_MENU_START":
+		s.write(w, `振込メニュー`)
+	case path == pathMenu:
+		s.write(w, `<table>`+payeeRow("0", testPayee)+`</table>`)
+	case path == pathSelect && r.FormValue("SELECT_REGISTER_ACCOUNT:_link_hidden_") != "":
+		s.write(w, `<table>`+payeeRow("0", testPayee)+payeeRow("1", "家賃")+`</table>`)
+	case path == pathSelect:
+		if _, ok := r.Form["SELECT_REGISTER_ACCOUNT:_idJsp431:0:_idJsp446"]; !ok {
+			s.write(w, `<div class="errortxt">振込先を選択してください。</div>`)
+			return
+		}
+		s.write(w, `<form id="FORM" name="FORM" method="post" action="/MS/main/fcs/rb/fes/jsp/mainservice/Transfer/TransferInput/TransferInput/TransferInput.jsp">
+<input type="hidden" name="FORM:DEBIT_OWNER_NAME_KANA" value="ﾗｸﾃﾝ ﾀﾛｳ">
+<input type="text" name="FORM:AMOUNT" value="">
+<input type="submit" name="FORM:_idJsp230" id="FORM:_idJsp230" value="次へ（確認）">
+</form>`)
+	case path == pathInput:
+		if _, ok := r.Form["FORM:_idJsp230"]; !ok || r.FormValue("FORM:DEBIT_OWNER_NAME_KANA") != utils.ToSJIS("ﾗｸﾃﾝ ﾀﾛｳ") {
+			s.write(w, `<div class="errortxt">入力内容に誤りがあります。</div>`)
+			return
+		}
+		if r.FormValue("FORM:AMOUNT") == "2000000" {
+			s.write(w, `<div class="errortxt">振込金額が支払可能残高を超えています。</div>`)
+			return
+		}
+		s.amount = r.FormValue("FORM:AMOUNT")
+		s.token = fmt.Sprintf("ST%d", s.seq)
+		shown := s.amount
+		if s.badQuote {
+			shown = "1" + shown
+		}
+		s.write(w, `<form id="SECURITY_BOARD" name="SECURITY_BOARD" method="post" action="/MS/main/fcs/rb/fes/jsp/mainservice/Transfer/TransferConfirm/TransferConfirm/TransferConfirm.jsp">
+<table>
+<tr><th><div>振込先</div></th><td>テスト銀行 本店 普通 1111111 ﾃｽﾄ ﾀﾛｳ</td></tr>
+<tr><th><div>振込金額</div></th><td>`+shown+`円</td></tr>
+<tr><th><div>振込予定日</div></th><td>2020/01/10(金)</td></tr>
+<tr><th><div>振込手数料</div></th><td>165円</td></tr>
+</table>
+<input type="hidden" name="SECURITY_BOARD:TOKEN" value="`+s.token+`">
+<input type="password" name="SECURITY_BOARD:USER_PASSWORD" value="">
+<input type="submit" name="SECURITY_BOARD:_idJsp250" value="振込実行">
+</form>`)
+	case path == pathConfirm:
+		if s.amount == "" || r.FormValue("SECURITY_BOARD:TOKEN") != s.token || r.FormValue("SECURITY_BOARD:USER_PASSWORD") != testPass2 {
+			s.write(w, `<div class="errortxt">暗証番号が正しくありません。</div>`)
+			return
+		}
+		s.committed = append(s.committed, s.amount)
+		s.amount = ""
+		s.write(w, fmt.Sprintf(`<table><tr><th><div>備考</div></th><td><div class="innercell">0123-%04d</div></td></tr></table>`, len(s.committed)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func payeeRow(id, name string) string {
+	return `<tr><td class="left"><div class="innercellline"><span class="bold">` + name + `</span></div></td>` +
+		`<td class="center"><div class="innercellline"><input id="SELECT_REGISTER_ACCOUNT:_idJsp431:` + id + `:_idJsp446" name="SELECT_REGISTER_ACCOUNT:_idJsp431:` + id + `:_idJsp446" type="submit" value="選択"></div></td></tr>`
+}
+
+// write writes a page with a new ViewState.
+func (s *fakeServer) write(w http.ResponseWriter, body string) {
+	s.seq++
+	s.viewState = fmt.Sprintf("VS%06d", s.seq)
+	w.Header().Set("Content-Type", "text/html; charset=Shift_JIS")
+	fmt.Fprint(w, utils.ToSJIS(`<html><body>`+body+
+		`<input type="hidden" name="javax.faces.ViewState" id="javax.faces.ViewState" value="`+s.viewState+`">`+
+		`</body></html>`))
+}
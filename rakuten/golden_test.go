@@ -0,0 +1,54 @@
+package rakuten
+
+import (
+	"testing"
+	"time"
+
+	"github.com/binzume/gobanking/common"
+	"github.com/binzume/gobanking/utils"
+	"github.com/binzume/gobanking/utils/golden"
+)
+
+// newParser returns an Account to parse pages in strict mode without a session.
+func newParser() *Account {
+	log := utils.NewLog(nil, bankID)
+	return &Account{log: log, check: utils.NewChecker(nil, bankID, log, nil)}
+}
+
+func TestGoldenBalance(t *testing.T) {
+	a := newParser()
+	if err := a.parseTop(golden.Load(t, "balance.html")); err != nil {
+		t.Fatal(err)
+	}
+	golden.Assert(t, "balance.golden.json", struct {
+		Account   common.BankAccount `json:"account"`
+		Balance   int64              `json:"balance"`
+		LastLogin time.Time          `json:"last_login"`
+	}{a.BankAccount, a.balance, a.lastLogin})
+}
+
+func TestGoldenTransferConfirm(t *testing.T) {
+	a := newParser()
+	tr, err := a.parseTransferConfirm(golden.Load(t, "transfer_confirm.html"), 30000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := tr.State.(*transferState)
+	golden.Assert(t, "transfer_confirm.golden.json", struct {
+		Quote  *common.TransferQuote `json:"quote"`
+		Token  string                `json:"token"`
+		Button string                `json:"button"`
+		Action string                `json:"action"`
+	}{tr, st.token, st.button, st.action})
+}
+
+func TestGoldenHistoryCSV(t *testing.T) {
+	a := newParser()
+	csv := golden.Load(t, "history.csv")
+	check := a.check.Page("CreditDebitInquiry", csv)
+	trs := parseHistoryCSV(csv, check)
+	if err := check.Err(); err != nil {
+		t.Fatal(err)
+	}
+	golden.Assert(t, "history.golden.json", trs)
+}
@@ -0,0 +1,27 @@
+package rakuten
+
+import (
+	"context"
+
+	"github.com/binzume/gobanking/common"
+)
+
+// LegacyAccount is returned by Login for compatibility. Methods don't take context.Context and use context.Background().
+// Common methods are provided by common.Legacy. Use LoginContext in new code.
+type LegacyAccount struct {
+	common.Legacy
+	*common.BankAccount
+	acc *Account
+}
+
+func newLegacyAccount(a *Account) *LegacyAccount {
+	return &LegacyAccount{Legacy: common.Legacy{Account: a}, BankAccount: &a.BankAccount, acc: a}
+}
+
+func (a *LegacyAccount) GetRegistered() (map[string]string, error) {
+	return a.acc.GetRegistered(context.Background())
+}
+
+func (a *LegacyAccount) GetRegistered2() (map[string]string, error) {
+	return a.acc.GetRegistered2(context.Background())
+}
@@ -1,63 +1,147 @@
 package rakuten
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"html"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/transform"
-
 	"github.com/binzume/gobanking/common"
 	"github.com/binzume/gobanking/utils"
+	"github.com/binzume/gobanking/utils/dom"
 )
 
 type Account struct {
 	common.BankAccount
 
 	client    *http.Client
+	log       *utils.Log
+	diag      *utils.Diagnostics
+	check     *utils.Checker
+	id        string
+	baseUrl   string
+	baseUrlMS string
 	viewState string
+	charset   string // charset to send the form
+	loggedIn  bool
+	relogin   *utils.Relogin
+	keepalive utils.Keepalive
 
 	balance   int64
 	userName  string
 	lastLogin time.Time
 }
 
+type transferState struct {
+	token  string
+	button string
+	action string
+}
+
+const bankID = "rakuten"
 const BankCode = "0036"
 const BankName = "楽天銀行"
-const baseurlMS = "https://fes.rakuten-bank.co.jp/MS/main/"
-const baseurl = "https://fes.rakuten-bank.co.jp/XMS/"
+const defaultBaseUrl = "https://fes.rakuten-bank.co.jp/XMS/"
 
-func Login(id, password string, options map[string]interface{}) (*Account, error) {
-	client, err := utils.NewHttpClient()
+func init() {
+	common.Register(bankID, driver{})
+	utils.SetRateLimit("rakuten-bank.co.jp", utils.RateLimit{Interval: time.Second, Burst: 3})
+}
+
+type driver struct{}
+
+func (driver) Login(ctx context.Context, id, password string, options map[string]interface{}) (common.Account, error) {
+	return LoginContext(ctx, id, password, options)
+}
+
+func (driver) Resume(ctx context.Context, s *common.Session, options map[string]interface{}) (common.Account, error) {
+	return Resume(ctx, s, options)
+}
+
+// Login is LoginContext without context. Parsing is lenient unless options["strict"] is specified.
+// The returned LegacyAccount has the old methods without context for compatibility.
+func Login(id, password string, options map[string]interface{}) (*LegacyAccount, error) {
+	a, err := LoginContext(context.Background(), id, password, utils.Lenient(options))
+	if a == nil {
+		return nil, err
+	}
+	return newLegacyAccount(a), err
+}
+
+func LoginContext(ctx context.Context, id, password string, options map[string]interface{}) (*Account, error) {
+	log := utils.NewLog(options, bankID)
+	client, err := utils.NewHttpClientFromOptions(options, log)
 	if err != nil {
 		return nil, err
 	}
-	a := &Account{client: client}
-	err = a.Login(id, password, options)
+	diag := utils.NewDiagnostics(options, bankID, log)
+	a := &Account{client: client, log: log, diag: diag, check: utils.NewChecker(options, bankID, log, diag)}
+	a.setEndpoints(options)
+	err = a.Login(ctx, id, password, options)
 	return a, err
 }
 
-func (a *Account) Login(id, password string, options map[string]interface{}) error {
+// Resume restores a session saved by SaveSession().
+func Resume(ctx context.Context, s *common.Session, options map[string]interface{}) (*Account, error) {
+	log := utils.NewLog(options, bankID)
+	client, err := utils.NewHttpClientFromOptions(options, log)
+	if err != nil {
+		return nil, err
+	}
+	err = utils.ImportCookies(client, s.Cookies)
+	if err != nil {
+		return nil, err
+	}
+	diag := utils.NewDiagnostics(options, bankID, log)
+	a := &Account{client: client, log: log, diag: diag, check: utils.NewChecker(options, bankID, log, diag), id: s.ID, viewState: s.Values["viewState"], loggedIn: true}
+	a.setEndpoints(options)
+	err = a.Refresh(ctx)
+	if err == nil && a.AccountNum == "" {
+		err = common.NewBankError(bankID, common.ErrSessionExpired, "account number not found")
+	}
+	return a, common.SessionRejected(bankID, err)
+}
+
+// setEndpoints sets URLs from options["base_url"] (.../XMS/) and options["base_url_ms"] (.../MS/main/).
+// base_url_ms defaults to ../MS/main/ of base_url.
+func (a *Account) setEndpoints(options map[string]interface{}) {
+	a.baseUrl = utils.OptionURL(options, utils.BaseURLKey, defaultBaseUrl)
+	ms := "../MS/main/"
+	if u, err := url.Parse(a.baseUrl); err == nil {
+		if m, err := u.Parse(ms); err == nil {
+			ms = m.String()
+		}
+	}
+	a.baseUrlMS = utils.OptionURL(options, "base_url_ms", ms)
+}
+
+func (a *Account) SaveSession() (*common.Session, error) {
+	values := map[string]string{"viewState": a.viewState}
+	return &common.Session{Bank: bankID, ID: a.id, Cookies: utils.ExportCookies(a.client), Values: values, SavedAt: time.Now()}, nil
+}
+
+func (a *Account) Login(ctx context.Context, id, password string, options map[string]interface{}) error {
+	a.id = id
+	a.loggedIn = false
+	a.relogin = utils.NewRelogin(bankID, id, password, options, a.log)
+	a.log.AddSecret(id, password)
 
-	qa := map[string]string{}
-	for k, v := range options {
-		qa[k] = v.(string)
+	qa := utils.SecretWords(options)
+	for _, ans := range qa {
+		a.log.AddSecret(ans, utils.ToSJIS(ans))
 	}
-	_, err := a.getMS("RbS?CurrentPageID=START&COMMAND=LOGIN")
+	res, err := a.getMS(ctx, "RbS?CurrentPageID=START&COMMAND=LOGIN")
 	if err != nil {
 		return err
 	}
 	if a.viewState == "" {
-		return errors.New("invalid response (viewState)")
+		return a.layoutError("START", res, "invalid response (viewState)")
 	}
 
 	params := map[string]string{
@@ -67,13 +151,13 @@ func (a *Account) Login(id, password string, options map[string]interface{}) err
 		"LOGIN:USER_ID":              id,
 		"LOGIN:LOGIN_PASSWORD":       password,
 	}
-	res, err := a.post("security/fcs/rb/fes/views/mainservice/Security/LoginAuthentication/Login/Login", params)
+	res, err = a.post(ctx, "security/fcs/rb/fes/views/mainservice/Security/LoginAuthentication/Login/Login", params)
 	if err != nil {
 		return err
 	}
 
-	if strings.Contains(res, "INPUT_FORM:SECRET_WORD") {
-		qq := getMatched(res, `(?s)質問<.*?>\s*([^\s<]+)\s*<`, "")
+	if doc := dom.Parse(res); doc.Find(dom.Name("INPUT_FORM:SECRET_WORD")) != nil {
+		qq := labeled(doc, "質問")
 		ans := ""
 		for k, v := range qa {
 			if strings.Contains(qq, k) {
@@ -81,51 +165,66 @@ func (a *Account) Login(id, password string, options map[string]interface{}) err
 			}
 		}
 
-		log.Println("q:", qq, ans != "")
+		a.log.Debug("secret word", "question", qq, "answered", ans != "")
 		params := map[string]string{
 			"INPUT_FORM_SUBMIT":        "1",
 			"INPUT_FORM:_link_hidden_": "",
 			"INPUT_FORM:_idJsp157":     "INPUT_FORM:_idJsp157",
-			"INPUT_FORM:TOKEN":         getMatched(res, `name="INPUT_FORM:TOKEN"\s+value="([^"]+)"`, ""),
-			"INPUT_FORM:SECRET_WORD":   utils.ToSJIS(ans),
+			"INPUT_FORM:TOKEN":         doc.InputValue("INPUT_FORM:TOKEN"),
+			"INPUT_FORM:SECRET_WORD":   ans,
 		}
-		_, err := a.post("commonservice/Security/LoginAuthentication/SecretWordAuthentication/SecretWordAuthentication", params)
+		_, err := a.post(ctx, "commonservice/Security/LoginAuthentication/SecretWordAuthentication/SecretWordAuthentication", params)
 		if err != nil {
 			return err
 		}
 	}
 
-	res, err = a.get("inquiry/gns?COMMAND=BALANCE_INQUIRY_START&CurrentPageID=HEADER_FOOTER_LINK")
+	res, err = a.get(ctx, "inquiry/gns?COMMAND=BALANCE_INQUIRY_START&CurrentPageID=HEADER_FOOTER_LINK")
 	if err != nil {
 		return err
 	}
 	if a.viewState == "" {
-		return errors.New("login error")
+		return common.NewBankError(bankID, common.ErrLoginRejected, "login error")
 	}
 
-	a.parseTop(html.UnescapeString(res))
-
-	return err
+	if err := a.parseTop(res); err != nil {
+		return err
+	}
+	a.loggedIn = true
+	return nil
 }
 
-func (a *Account) parseTop(res string) {
-	a.balance, _ = getMatchedInt(res, `(?s)総額（評価額）.*?>\s*([0-9,]+)\s*<`)
-	// a.balance, _ = getMatchedInt(res, `(?s)（支払可能残高）.*?>\s*([0-9,]+)\s*<`)
-	lastLoginStr := getMatched(res, `(?s)<span class="login-date02">\s*([^<]+?)\s*<`, "")
+func (a *Account) parseTop(res string) error {
+	doc := dom.Parse(res)
+	check := a.check.Page("BALANCE_INQUIRY", res)
+	lastLoginStr := doc.Find(dom.Class("login-date02")).Text()
 	if t, err := time.Parse("2006/01/02 15:04:05", lastLoginStr); err == nil {
 		a.lastLogin = t
+	} else if lastLoginStr != "" {
+		check.Invalid("login-date02", lastLoginStr)
 	}
 
+	number := dom.MatchText(regexp.MustCompile(`^\d+$`))
 	a.BankCode = BankCode
 	a.BankName = BankName
-	a.OwnerName = getMatched(res, `(?s)<span class="smediumbold marginright4">\s*([^<]+?)\s*<`, "")
-	a.BranchCode = getMatched(res, `(?s)<span class="branch-number">.*?>.*?>(\d+)`, "")
-	a.AccountNum = getMatched(res, `(?s)<span class="account-number">.*?>.*?>(\d+)`, "")
-	a.BranchName = getMatched(res, `(?s)<span class="branch-name">([^<]+支店)`, "")
+	a.OwnerName = doc.Find(dom.And(dom.Class("smediumbold"), dom.Class("marginright4"))).Text()
+	a.BranchCode = doc.Find(dom.Class("branch-number")).Find(number).Text()
+	a.AccountNum = doc.Find(dom.Class("account-number")).Find(number).Text()
+	a.log.AddSecret(a.AccountNum, a.OwnerName)
+	a.BranchName = regexp.MustCompile(`^.*支店`).FindString(doc.Find(dom.Class("branch-name")).Text())
+
+	amount := dom.MatchText(regexp.MustCompile(`^[\d,]+$`))
+	balance, err := utils.ParseAmount(doc.Find(dom.ContainsText("総額（評価額）")).Following(amount).Text())
+	if err != nil {
+		check.Missing("総額（評価額）")
+	}
+	a.balance = balance
+	return check.Err()
 }
 
-func (a *Account) Logout() error {
-	_, err := a.get("gns?COMMAND=LOGOUT_START&CurrentPageID=HEADER_FOOTER_LINK")
+func (a *Account) Logout(ctx context.Context) error {
+	a.keepalive.Stop()
+	_, err := a.get(ctx, "gns?COMMAND=LOGOUT_START&CurrentPageID=HEADER_FOOTER_LINK")
 	return err
 }
 
@@ -133,44 +232,82 @@ func (a *Account) AccountInfo() *common.BankAccount {
 	return &a.BankAccount
 }
 
-func (a *Account) TotalBalance() (int64, error) {
+func (a *Account) TotalBalance(ctx context.Context) (int64, error) {
 	return a.balance, nil
 }
 
-func (a *Account) LastLogin() (time.Time, error) {
+func (a *Account) LastLogin(ctx context.Context) (time.Time, error) {
 	return a.lastLogin, nil
 }
 
-func (a *Account) Recent() ([]*common.Transaction, error) {
-	res, err := a.get("inquiry/gns?COMMAND=CREDIT_DEBIT_INQUIRY_START&CurrentPageID=HEADER_FOOTER_LINK")
-	re1 := regexp.MustCompile(`(?s)<tr class="td\d\dline">(.*?)<\/tr>`)
-	re2 := regexp.MustCompile(`(?s)<td[^>]*>\s*<[^>]+>\s*(.*?)<[^>]+>\s*<\/td>`)
-	re3 := regexp.MustCompile(`<[^>]+>`)
+func (a *Account) Recent(ctx context.Context) ([]*common.Transaction, error) {
+	var res string
+	err := a.relogin.Retry(ctx, a, func() (err error) {
+		res, err = a.get(ctx, "inquiry/gns?COMMAND=CREDIT_DEBIT_INQUIRY_START&CurrentPageID=HEADER_FOOTER_LINK")
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	rowRe := regexp.MustCompile(`^td\d\dline$`)
+	check := a.check.Page("CREDIT_DEBIT_INQUIRY", res)
 
 	trs := []*common.Transaction{}
-	for _, match := range re1.FindAllStringSubmatch(res, -1) {
-		cell := re2.FindAllStringSubmatch(match[1], -1)
-		if len(cell) > 3 {
-			var tr common.Transaction
-			if t, err := time.Parse("2006/01/02", strings.TrimSpace(cell[0][1])); err == nil {
-				tr.Date = t
-			}
-			tr.Description = html.UnescapeString(cell[1][1])
-			cell[2][1] = strings.TrimSpace(re3.ReplaceAllString(cell[2][1], ""))
-			tr.Amount, _ = strconv.ParseInt(strings.Replace(cell[2][1], ",", "", -1), 10, 32)
-			tr.Balance, _ = strconv.ParseInt(strings.Replace(cell[3][1], ",", "", -1), 10, 32)
-			trs = append(trs, &tr)
+	for _, row := range dom.Parse(res).FindAll(dom.Tag("tr")) {
+		if !rowRe.MatchString(row.Attr("class")) {
+			continue
+		}
+		cell := row.Cells()
+		if len(cell) <= 3 {
+			check.Invalid("row", row.Text())
+			continue
+		}
+		var tr common.Transaction
+		if t, err := time.Parse("2006/01/02", cell[0].Text()); err == nil {
+			tr.Date = t
+		} else {
+			check.Invalid("date", cell[0].Text())
+		}
+		tr.Description = cell[1].Text()
+		tr.Kind, tr.Counterparty = common.ParseDescription(tr.Description)
+		tr.Raw = map[string]string{}
+		for i, c := range cell {
+			tr.Raw[strconv.Itoa(i)] = c.Text()
+		}
+		if tr.Amount, err = common.ParseMoney(cell[2].Text(), common.JPY); err != nil {
+			check.Invalid("amount", cell[2].Text())
+		}
+		if tr.Balance, err = common.ParseMoney(cell[3].Text(), common.JPY); err != nil {
+			check.Invalid("balance", cell[3].Text())
 		}
+		trs = append(trs, &tr)
 	}
 
 	// reverse
 	for i, j := 0, len(trs)-1; i < j; i, j = i+1, j-1 {
 		trs[i], trs[j] = trs[j], trs[i]
 	}
-	return trs, err
+	return trs, check.Err()
 }
 
-func (a *Account) History(from, to time.Time) ([]*common.Transaction, error) {
+// 24ヶ月，3000件まで
+var historyLimits = common.HistoryLimits{MaxMonths: 24, MaxRows: 3000}
+
+func (a *Account) HistoryLimits() common.HistoryLimits {
+	return historyLimits
+}
+
+func (a *Account) History(ctx context.Context, from, to time.Time) ([]*common.Transaction, error) {
+	return common.FetchHistory(ctx, historyLimits, from, to, func(ctx context.Context, from, to time.Time) (trs []*common.Transaction, err error) {
+		err = a.relogin.Retry(ctx, a, func() (err error) {
+			trs, err = a.fetchHistory(ctx, from, to)
+			return
+		})
+		return
+	})
+}
+
+func (a *Account) fetchHistory(ctx context.Context, from, to time.Time) ([]*common.Transaction, error) {
 	params := map[string]string{
 		"FORM_DOWNLOAD_SUBMIT":                   "1",
 		"FORM_DOWNLOAD:_link_hidden_":            "",
@@ -183,26 +320,103 @@ func (a *Account) History(from, to time.Time) ([]*common.Transaction, error) {
 		"FORM_DOWNLOAD:EXPECTED_DATE_TO_DAY":     fmt.Sprintf("%02d", to.Day()),
 		"FORM_DOWNLOAD:DOWNLOAD_TYPE":            "0",
 	}
-	res, err := a.post("mainservice/Inquiry/CreditDebitInquiry/CreditDebitInquiry/CreditDebitInquiry", params)
+	res, err := a.post(ctx, "mainservice/Inquiry/CreditDebitInquiry/CreditDebitInquiry/CreditDebitInquiry", params)
+	if err != nil {
+		return nil, err
+	}
+	check := a.check.Page("CreditDebitInquiry", res)
+	trs := parseHistoryCSV(res, check)
+	return trs, check.Err()
+}
+
+// parseHistoryCSV parses the downloaded CSV. The first line is the header.
+func parseHistoryCSV(res string, check *utils.PageCheck) []*common.Transaction {
 	trs := []*common.Transaction{}
-	for _, line := range strings.Split(res, "\n")[1:] {
-		var row = strings.Split(line, ",")
-		if len(row) >= 4 {
-			var tr common.Transaction
-			if t, err := time.Parse("20060102", row[0]); err == nil {
-				tr.Date = t
+	lines := strings.Split(res, "\n")
+	header := strings.Split(strings.TrimSpace(lines[0]), ",")
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var row = strings.Split(strings.TrimSpace(line), ",")
+		if len(row) < 4 {
+			check.Invalid("row", line)
+			continue
+		}
+		var tr common.Transaction
+		tr.Raw = map[string]string{}
+		for i, v := range row {
+			if i < len(header) {
+				tr.Raw[header[i]] = v
+			} else {
+				tr.Raw[strconv.Itoa(i)] = v
 			}
-			tr.Amount, _ = strconv.ParseInt(row[1], 10, 64)
-			tr.Balance, _ = strconv.ParseInt(row[2], 10, 64)
-			tr.Description = row[3]
-			trs = append(trs, &tr)
 		}
+		if t, err := time.Parse("20060102", row[0]); err == nil {
+			tr.Date = t
+		} else {
+			check.Invalid("date", row[0])
+		}
+		var err error
+		if tr.Amount, err = common.ParseMoney(row[1], common.JPY); err != nil {
+			check.Invalid("amount", row[1])
+		}
+		if tr.Balance, err = common.ParseMoney(row[2], common.JPY); err != nil {
+			check.Invalid("balance", row[2])
+		}
+		tr.Description = row[3]
+		tr.Kind, tr.Counterparty = common.ParseDescription(tr.Description)
+		trs = append(trs, &tr)
 	}
-	return trs, err
+	return trs
+}
+
+// StartKeepalive fetches the balance inquiry page while the session is idle.
+func (a *Account) StartKeepalive(ctx context.Context, interval time.Duration, onError func(error)) {
+	a.keepalive.Start(ctx, interval, func(ctx context.Context) error {
+		_, err := a.get(ctx, "inquiry/gns?COMMAND=BALANCE_INQUIRY_START&CurrentPageID=HEADER_FOOTER_LINK")
+		return err
+	}, onError)
 }
 
-func (a *Account) GetRegistered() (map[string]string, error) {
-	_, err := a.get("gns?COMMAND=TRANSFER_MENU_START&CurrentPageID=HEADER_FOOTER_LINK")
+func (a *Account) Refresh(ctx context.Context) error {
+	var res string
+	err := a.relogin.Retry(ctx, a, func() (err error) {
+		res, err = a.get(ctx, "inquiry/gns?COMMAND=BALANCE_INQUIRY_START&CurrentPageID=HEADER_FOOTER_LINK")
+		return
+	})
+	if err != nil {
+		return err
+	}
+	return a.parseTop(res)
+}
+
+func (a *Account) Payees(ctx context.Context) ([]*common.Payee, error) {
+	var registered, registered2 map[string]string
+	err := a.relogin.Retry(ctx, a, func() (err error) {
+		registered, err = a.GetRegistered(ctx)
+		if err != nil {
+			return
+		}
+		registered2, err = a.GetRegistered2(ctx)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, id := range registered2 {
+		registered[name] = id
+	}
+	var payees []*common.Payee
+	for name := range registered {
+		payees = append(payees, &common.Payee{Name: name})
+	}
+	sort.Slice(payees, func(i, j int) bool { return payees[i].Name < payees[j].Name })
+	return payees, nil
+}
+
+func (a *Account) GetRegistered(ctx context.Context) (map[string]string, error) {
+	_, err := a.get(ctx, "gns?COMMAND=TRANSFER_MENU_START&CurrentPageID=HEADER_FOOTER_LINK")
 	if err != nil {
 		return nil, err
 	}
@@ -211,64 +425,58 @@ func (a *Account) GetRegistered() (map[string]string, error) {
 		"FORM_SUBMIT":        "1",
 		"FORM:_link_hidden_": "FORM:_idJsp430",
 	}
-	res, err := a.post("mainservice/Transfer/TransferMenu/TransferMenu/TransferMenu", params)
+	res, err := a.post(ctx, "mainservice/Transfer/TransferMenu/TransferMenu/TransferMenu", params)
 	if err != nil {
 		return nil, err
 	}
 
-	re1 := regexp.MustCompile(`(?s)<tr>\s*<td[^>]*>\s*<div class="innercellline">.*?<input id="SELECT_REGISTER_ACCOUNT:_idJsp431:[^>]+>\s*</div>\s*</td>\s*</tr>`)
-	list := map[string]string{}
-	for _, match := range re1.FindAllString(res, -1) {
-		// log.Println(match)
-		name := getMatched(match, `(?s)<div class="innercellline">\s*<span[^>]*>([^<]+)</span>`, "")
-		id := getMatched(match, `<input [^>]*name="SELECT_REGISTER_ACCOUNT:_idJsp431:(\w+):_idJsp446"`, "")
-		if name != "" && id != "" {
-			list[name] = id
-		}
-	}
-	return list, nil
+	return parsePayees(res), nil
 }
 
-func (a *Account) GetRegistered2() (map[string]string, error) {
+func (a *Account) GetRegistered2(ctx context.Context) (map[string]string, error) {
 
 	params := map[string]string{
 		"SELECT_REGISTER_ACCOUNT_SUBMIT":        "1",
 		"SELECT_REGISTER_ACCOUNT:_link_hidden_": "SELECT_REGISTER_ACCOUNT:_idJsp416", // or 412(all)
 		"KANA_INDEX_KEY":                        "",
 	}
-	res, err := a.post("mainservice/Transfer/TransferMenu/TransferSelect/TransferSelect", params)
+	res, err := a.post(ctx, "mainservice/Transfer/TransferMenu/TransferSelect/TransferSelect", params)
 	if err != nil {
 		return nil, err
 	}
 
-	re1 := regexp.MustCompile(`(?s)<tr>\s*<td[^>]*>\s*<div class="innercellline">.*?<input id="SELECT_REGISTER_ACCOUNT:_idJsp431:[^>]+>\s*</div>\s*</td>\s*</tr>`)
+	return parsePayees(res), nil
+}
+
+// parsePayees returns ids of registered accounts by name.
+func parsePayees(res string) map[string]string {
+	re := regexp.MustCompile(`^SELECT_REGISTER_ACCOUNT:_idJsp431:(\w+):_idJsp446$`)
 	list := map[string]string{}
-	for _, match := range re1.FindAllString(res, -1) {
-		// log.Println(match)
-		name := getMatched(match, `(?s)<div class="innercellline">\s*<span[^>]*>([^<]+)</span>`, "")
-		id := getMatched(match, `<input [^>]*name="SELECT_REGISTER_ACCOUNT:_idJsp431:(\w+):_idJsp446"`, "")
-		if name != "" && id != "" {
-			list[name] = id
+	for _, input := range dom.Parse(res).FindAll(dom.IDPrefix("SELECT_REGISTER_ACCOUNT:_idJsp431:")) {
+		m := re.FindStringSubmatch(input.Attr("name"))
+		name := input.Closest(dom.Tag("tr")).Find(dom.Class("innercellline")).Find(dom.Tag("span")).Text()
+		if name != "" && m != nil {
+			list[name] = m[1]
 		}
 	}
-	return list, nil
+	return list
 }
 
 // transfar api
-func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64) (common.TransferState, error) {
-	registered, err := a.GetRegistered()
+func (a *Account) NewTransferToRegisteredAccount(ctx context.Context, targetName string, amount int64) (*common.TransferQuote, error) {
+	registered, err := a.GetRegistered(ctx)
 	if err != nil {
 		return nil, err
 	}
 	n, ok := registered[targetName]
 	if !ok {
-		registered, err = a.GetRegistered2()
+		registered, err = a.GetRegistered2(ctx)
 		if err != nil {
 			return nil, err
 		}
 		n, ok = registered[targetName]
 		if !ok {
-			return nil, fmt.Errorf("not registered: %s in %v", targetName, registered)
+			return nil, common.NewBankError(bankID, common.ErrPayeeNotRegistered, fmt.Sprintf("%s in %v", targetName, registered))
 		}
 	}
 
@@ -278,62 +486,108 @@ func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64
 		"SELECT_REGISTER_ACCOUNT:_idJsp431:" + n + ":_idJsp446": "SELECT_REGISTER_ACCOUNT:_idJsp431:" + n + ":_idJsp446",
 		"KANA_INDEX_KEY": "",
 	}
-	res, err := a.post("mainservice/Transfer/TransferMenu/TransferSelect/TransferSelect", params)
+	res, err := a.post(ctx, "mainservice/Transfer/TransferMenu/TransferSelect/TransferSelect", params)
 	if err != nil {
 		return nil, err
 	}
 	// log.Println(res)
 
-	action := getMatched(res, `name="FORM" [^>]*action="/MS/main/fcs/rb/fes/jsp/([^"]+)\.jsp"`, "")
-	btn := getMatched(res, `name="(FORM:_idJsp\d+)" [^>]*value="次へ（確認）"`, "")
+	doc := dom.Parse(res)
+	action := formAction(doc, "FORM")
+	btn := doc.Find(dom.And(dom.Tag("input"), dom.Attr("value", "次へ（確認）"))).Attr("name")
 	params = map[string]string{
 		"FORM_SUBMIT":                "1",
 		"FORM:_link_hidden_":         "",
 		btn:                          btn, // _idJsp230 181
 		"FORM:COMMENT":               "",
-		"FORM:DEBIT_OWNER_NAME_KANA": utils.ToSJIS(getMatched(res, `name="FORM:DEBIT_OWNER_NAME_KANA" [^>]*value="([^"]+)"`, "")),
+		"FORM:DEBIT_OWNER_NAME_KANA": doc.InputValue("FORM:DEBIT_OWNER_NAME_KANA"),
 		"FORM:AMOUNT":                fmt.Sprint(amount),
 	}
-	res, err = a.post(action, params)
+	res, err = a.post(ctx, action, params)
 	if err != nil {
 		return nil, err
 	}
 	// log.Println(res)
+	return a.parseTransferConfirm(res, amount)
+}
 
-	token := getMatched(res, `name="SECURITY_BOARD:TOKEN" [^>]*value="([^"]*)"`, "")
-	fee := getMatched(res, `(?s)振込手数料</div>\s*</th>\s*<td[^>]*>\s*(.*?)</td>`, "")
-	date := getMatched(res, `(?s)振込予定日</div>\s*</th>\s*<td[^>]*>\s*(.*?)</td>`, "")
-	to := getMatched(res, `(?s)振込先</div>\s*</th>\s*<td[^>]*>\s*(.*?)</td>`, "")
+// parseTransferConfirm parses the confirmation page of the transfer of amount.
+func (a *Account) parseTransferConfirm(res string, amount int64) (*common.TransferQuote, error) {
+	doc := dom.Parse(res)
+	token := doc.InputValue("SECURITY_BOARD:TOKEN")
+	check := a.check.Page("TRANSFER_CONFIRM", res)
+	if s := labeled(doc, "振込金額"); s == "" {
+		check.Fatal("振込金額", "")
+	} else if confirmed, err := utils.ParseAmount(s); err != nil || confirmed != amount {
+		check.Fatal("振込金額", s)
+	}
+	fee, err := utils.ParseAmount(labeled(doc, "振込手数料"))
+	if err != nil {
+		check.Fatal("振込手数料", labeled(doc, "振込手数料"))
+	}
+	date, err := utils.ParseDate(labeled(doc, "振込予定日"))
+	if err != nil {
+		check.Invalid("振込予定日", labeled(doc, "振込予定日"))
+	}
+	if err := check.Err(); err != nil {
+		return nil, err
+	}
 	if token == "" {
-		err = fmt.Errorf("get token error")
+		return nil, a.layoutError("TRANSFER_CONFIRM", res, "get token error")
+	}
+	bank, branch, account, name := splitPayee(labeled(doc, "振込先"))
+	btn := doc.Find(dom.And(dom.Tag("input"), dom.Attr("value", "振込実行"))).Attr("name")
+	action := formAction(doc, "SECURITY_BOARD")
+	return &common.TransferQuote{
+		Amount:        common.Yen(amount),
+		Fee:           common.Yen(fee),
+		Total:         common.Yen(amount + fee),
+		ScheduledDate: date,
+		PayeeName:     name,
+		PayeeBank:     bank,
+		PayeeBranch:   branch,
+		PayeeAccount:  account,
+		State:         &transferState{token: token, button: btn, action: action},
+	}, nil
+}
+
+// splitPayee splits the payee cell like "テスト銀行 本店 普通 1111111 ﾃｽﾄ ﾀﾛｳ".
+// The whole text is returned as the name if the account number is not found.
+func splitPayee(s string) (bank, branch, account, name string) {
+	f := strings.Fields(s)
+	re := regexp.MustCompile(`^\d+$`)
+	for i := 2; i < len(f); i++ {
+		if re.MatchString(f[i]) {
+			return f[0], f[1], f[i], strings.Join(f[i+1:], " ")
+		}
 	}
-	feeint, _ := strconv.Atoi(strings.Replace(fee, ",", "", -1))
-	btn = getMatched(res, `name="(SECURITY_BOARD:_idJsp\d+)" [^>]*value="振込実行"`, "")
-	action = getMatched(res, `name="SECURITY_BOARD" [^>]*action="/MS/main/fcs/rb/fes/jsp/([^"]+)\.jsp"`, "")
-	return utils.TransferStateMap{"token": token, "button": btn, "action": action,
-		"fee_msg": fee, "fee": int(feeint), "date": date, "to": to, "amount": amount}, err
+	return "", "", "", s
 }
 
-func (a *Account) CommitTransfer(tr common.TransferState, pass2 string) (string, error) {
-	tr1, ok := tr.(utils.TransferStateMap)
+func (a *Account) CommitTransfer(ctx context.Context, tr *common.TransferQuote, pass2 string) (string, error) {
+	st, ok := tr.State.(*transferState)
 	if !ok {
 		return "", errors.New("invalid paramter type: tr")
 	}
-	button := tr1["button"].(string)
 	params := map[string]string{
 		"SECURITY_BOARD_SUBMIT":        "1",
 		"SECURITY_BOARD:_link_hidden_": "",
 		"SECURITY_BOARD:USER_PASSWORD": pass2,
-		"SECURITY_BOARD:TOKEN":         tr1["token"].(string),
-		button:                         button, // _idJsp250
+		"SECURITY_BOARD:TOKEN":         st.token,
+		st.button:                      st.button, // _idJsp250
+	}
+	res, err := a.post(ctx, st.action, params)
+	recptNo := regexp.MustCompile(`^\d+-\d+$`).FindString(labeled(dom.Parse(res), "備考"))
+	if recptNo == "" {
+		recptNo = res
 	}
-	res, err := a.post(tr1["action"].(string), params)
-	recptNo := getMatched(res, `(?s)備考</div>\s*</th>\s*<td[^>]*>\s*<div class="innercell">\s*(\d+-\d+)\s*</div>`, res)
 	return recptNo, err
 }
 
-func (a *Account) getMS(path string) (string, error) {
-	req, err := http.NewRequest("GET", baseurlMS+path, nil)
+func (a *Account) getMS(ctx context.Context, path string) (string, error) {
+	a.keepalive.Lock()
+	defer a.keepalive.Unlock()
+	req, err := http.NewRequestWithContext(ctx, "GET", a.baseUrlMS+path, nil)
 	if err != nil {
 		return "", err
 	}
@@ -342,8 +596,10 @@ func (a *Account) getMS(path string) (string, error) {
 	return a.request(req)
 }
 
-func (a *Account) get(path string) (string, error) {
-	req, err := http.NewRequest("GET", baseurl+path, nil)
+func (a *Account) get(ctx context.Context, path string) (string, error) {
+	a.keepalive.Lock()
+	defer a.keepalive.Unlock()
+	req, err := http.NewRequestWithContext(ctx, "GET", a.baseUrl+path, nil)
 	if err != nil {
 		return "", err
 	}
@@ -352,7 +608,12 @@ func (a *Account) get(path string) (string, error) {
 	return a.request(req)
 }
 
-func (a *Account) post(path string, params map[string]string) (string, error) {
+func (a *Account) post(ctx context.Context, path string, params map[string]string) (string, error) {
+	a.keepalive.Lock()
+	defer a.keepalive.Unlock()
+	if a.loggedIn && a.viewState == "" {
+		return "", common.NewBankError(bankID, common.ErrSessionExpired, "viewState lost")
+	}
 	values := url.Values{}
 
 	values.Set("javax.faces.ViewState", a.viewState)
@@ -360,7 +621,11 @@ func (a *Account) post(path string, params map[string]string) (string, error) {
 		values.Set(k, v)
 	}
 
-	req, err := http.NewRequest("POST", baseurl+path+".xhtml", strings.NewReader(values.Encode()))
+	body, err := utils.EncodeForm(values, a.charset)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseUrl+path+".xhtml", strings.NewReader(body))
 	if err != nil {
 		return "", err
 	}
@@ -377,26 +642,39 @@ func (a *Account) request(req *http.Request) (string, error) {
 	}
 
 	defer res.Body.Close()
-	b, err := ioutil.ReadAll(transform.NewReader(res.Body, japanese.ShiftJIS.NewDecoder()))
+	page, cs, err := utils.ReadBody(res)
 	if err != nil {
 		return "", err
 	}
-	doc := string(b)
+	doc := dom.Parse(page)
+	a.charset = utils.FormCharset(doc, cs)
 
-	if state := getMatched(doc, `name="javax.faces.ViewState" [^>]*value=["']([^"]+)["']`, ""); state != "" {
+	if state := doc.InputValue("javax.faces.ViewState"); state != "" {
 		a.viewState = state
 	}
 
-	if msg := getMatched(doc, `class="errortxt">(.+?)</`, ""); msg != "" {
-		return doc, fmt.Errorf("ERROR: %s", msg)
+	if msg := doc.Find(dom.Class("errortxt")).Text(); msg != "" {
+		return page, common.NewBankError(bankID, common.ClassifyMessage(msg), msg)
 	}
-	return doc, err
+	if a.loggedIn && (strings.Contains(page, "ViewExpiredException") || doc.Find(dom.Name("LOGIN:USER_ID")) != nil) {
+		a.viewState = ""
+		return page, common.NewBankError(bankID, common.ErrSessionExpired, "login page returned")
+	}
+	return page, err
+}
+
+// layoutError returns an ErrLayoutChanged error with the snapshot of the page.
+func (a *Account) layoutError(page, doc, msg string) error {
+	return a.diag.Snapshot(common.NewBankError(bankID, common.ErrLayoutChanged, msg), page, doc)
 }
 
-func getMatchedInt(htmlStr, reStr string) (int64, error) {
-	return strconv.ParseInt(strings.Replace(getMatched(htmlStr, reStr, ""), ",", "", -1), 10, 64)
+// labeled returns the text of the cell following the label. e.g. <th>振込先</th><td>...</td>
+func labeled(doc *dom.Node, label string) string {
+	return doc.Find(dom.ContainsText(label)).Following(dom.Tag("td")).Text()
 }
 
-func getMatched(htmlStr, reStr, def string) string {
-	return utils.GetMatched(htmlStr, reStr, def)
+// formAction returns the path of the form action without "/MS/main/fcs/rb/fes/jsp/" and ".jsp".
+func formAction(doc *dom.Node, name string) string {
+	action := doc.Find(dom.And(dom.Tag("form"), dom.Name(name))).Attr("action")
+	return strings.TrimSuffix(strings.TrimPrefix(action, "/MS/main/fcs/rb/fes/jsp/"), ".jsp")
 }
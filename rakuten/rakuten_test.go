@@ -0,0 +1,216 @@
+package rakuten
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/binzume/gobanking/common"
+	"github.com/binzume/gobanking/utils"
+)
+
+func login(s *fakeServer, password string, options map[string]interface{}) (*Account, error) {
+	opts := map[string]interface{}{
+		"出身地": testAnswer,
+	}
+	for k, v := range s.options() {
+		opts[k] = v
+	}
+	for k, v := range options {
+		opts[k] = v
+	}
+	return LoginContext(context.Background(), testID, password, opts)
+}
+
+func TestLogin(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+
+	info := acc.AccountInfo()
+	if info.AccountNum != "7654321" || info.BranchCode != "201" || info.BranchName != "ロック支店" || info.OwnerName != "楽天　太郎" {
+		t.Errorf("unexpected account info: %+v", info)
+	}
+	if balance, _ := acc.TotalBalance(ctx); balance != 1000000 {
+		t.Errorf("unexpected balance: %v", balance)
+	}
+	if last, _ := acc.LastLogin(ctx); !last.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected last login: %v", last)
+	}
+
+	recent, err := acc.Recent(ctx)
+	if err != nil {
+		t.Fatalf("recent failed: %v", err)
+	}
+	if len(recent) != 2 || recent[0].Amount != common.Yen(503000) || recent[1].Amount != common.Yen(-3000) || recent[1].Balance != common.Yen(1000000) {
+		t.Errorf("unexpected recent: %v", recent)
+	}
+
+	if err := acc.Logout(ctx); err != nil {
+		t.Errorf("logout failed: %v", err)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	s := newFakeServer(t)
+	_, err := login(s, "wrong", nil)
+	if !errors.Is(err, common.ErrLoginRejected) {
+		t.Errorf("expected ErrLoginRejected: %v", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	from := time.Now().AddDate(0, 0, -10)
+	trs, err := acc.History(context.Background(), from, time.Now())
+	if err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	if len(trs) != 2 || trs[0].Date.Day() != from.Day() || trs[1].Counterparty != "ラクテン ハナコ" || trs[1].Raw["入出金内容"] != trs[1].Description {
+		t.Errorf("unexpected history: %v", trs)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	s := newFakeServer(t)
+	s.shortRow = true
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	_, err = acc.Recent(context.Background())
+	var layoutErr *common.LayoutError
+	if !errors.As(err, &layoutErr) || layoutErr.Field != "row" || layoutErr.Page != "CREDIT_DEBIT_INQUIRY" {
+		t.Fatalf("expected LayoutError: %v", err)
+	}
+
+	acc, err = login(s, testPassword, map[string]interface{}{utils.StrictKey: false})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if recent, err := acc.Recent(context.Background()); err != nil || len(recent) != 2 {
+		t.Errorf("short rows must be skipped in lenient mode: %v %v", recent, err)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+
+	payees, err := acc.Payees(ctx)
+	if err != nil || len(payees) != 2 {
+		t.Fatalf("unexpected payees: %v %v", payees, err)
+	}
+	if _, err := acc.NewTransferToRegisteredAccount(ctx, "unknown", 1000); !errors.Is(err, common.ErrPayeeNotRegistered) {
+		t.Errorf("expected ErrPayeeNotRegistered: %v", err)
+	}
+	if _, err := acc.NewTransferToRegisteredAccount(ctx, testPayee, 2000000); !errors.Is(err, common.ErrInsufficientFunds) {
+		t.Errorf("expected ErrInsufficientFunds: %v", err)
+	}
+
+	tr, err := acc.NewTransferToRegisteredAccount(ctx, testPayee, 1000)
+	if err != nil {
+		t.Fatalf("failed to create transfer: %v", err)
+	}
+	if tr.Amount != common.Yen(1000) || tr.Fee != common.Yen(165) || tr.Total != common.Yen(1165) || tr.ScheduledDate.Day() != 10 {
+		t.Errorf("unexpected quote: %v", tr)
+	}
+	if tr.PayeeBank != "テスト銀行" || tr.PayeeBranch != "本店" || tr.PayeeAccount != "1111111" || tr.PayeeName != "ﾃｽﾄ ﾀﾛｳ" {
+		t.Errorf("unexpected payee: %v", tr)
+	}
+	recptNo, err := acc.CommitTransfer(ctx, tr, testPass2)
+	if err != nil || recptNo != "0123-0001" {
+		t.Fatalf("failed to commit: %v %v", recptNo, err)
+	}
+	if len(s.committed) != 1 || s.committed[0] != "1000" {
+		t.Errorf("unexpected transfers: %v", s.committed)
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	s.expire()
+	if err := acc.Refresh(context.Background()); !errors.Is(err, common.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired: %v", err)
+	}
+
+	acc, err = login(s, testPassword, map[string]interface{}{"relogin": true})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	s.expire()
+	if _, err := acc.Recent(context.Background()); err != nil {
+		t.Errorf("relogin failed: %v", err)
+	}
+}
+
+func TestTransferQuoteChanged(t *testing.T) {
+	s := newFakeServer(t)
+	s.badQuote = true
+	// the amount is checked even in lenient mode.
+	acc, err := login(s, testPassword, map[string]interface{}{utils.StrictKey: false})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	tr, err := acc.NewTransferToRegisteredAccount(context.Background(), testPayee, 1000)
+	var layoutErr *common.LayoutError
+	if tr != nil || !errors.As(err, &layoutErr) || layoutErr.Field != "振込金額" {
+		t.Errorf("expected LayoutError: %v %v", tr, err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+	saved, err := acc.SaveSession()
+	if err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	// sessions are stored as JSON.
+	b, _ := json.Marshal(saved)
+	var session common.Session
+	if err := json.Unmarshal(b, &session); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := Resume(ctx, &session, s.options())
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	if resumed.AccountInfo().AccountNum != acc.AccountInfo().AccountNum {
+		t.Errorf("unexpected account: %+v", resumed.AccountInfo())
+	}
+	if balance, _ := resumed.TotalBalance(ctx); balance != 1000000 {
+		t.Errorf("unexpected balance: %v", balance)
+	}
+
+	// banking.Resume logs in again if the session is expired.
+	s.expire()
+	if _, err := Resume(ctx, &session, s.options()); !errors.Is(err, common.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired: %v", err)
+	}
+	if _, err := login(s, testPassword, nil); err != nil {
+		t.Errorf("login failed: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package sbi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/binzume/gobanking/utils"
+)
+
+const (
+	testID       = "sbi-user"
+	testPassword = "password"
+)
+
+// fakeServer emulates SBI Net Bank. Pages are Shift_JIS and the session is kept in a cookie.
+type fakeServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	loggedIn bool
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// options returns login options to use the fake server.
+func (s *fakeServer) options() map[string]interface{} {
+	return map[string]interface{}{utils.BaseURLKey: s.URL + "/wpl/NBGate/"}
+}
+
+// expire invalidates the current session.
+func (s *fakeServer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loggedIn = false
+}
+
+const loginPage = `<form method="post" action="/wpl/NBGate/i010101CT"><input type="text" name="userName"><input type="password" name="loginPwdSet"></form>`
+
+func (s *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ParseForm()
+
+	switch r.URL.Path {
+	case "/wpl/NBGate/i010101CT":
+		if r.FormValue("userName") != testID || r.FormValue("loginPwdSet") != testPassword {
+			s.write(w, `<p class="errMsg">ユーザネームまたはWEBログインパスワードに誤りがあります。</p>`+loginPage)
+			return
+		}
+		s.loggedIn = true
+		http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "session", Path: "/"})
+		s.write(w, `<p>ログインしました</p>`)
+	case "/wpl/NBGate/i020101CT/DI02010100":
+		if c, err := r.Cookie("JSESSIONID"); err != nil || c.Value != "session" || !s.loggedIn {
+			s.write(w, loginPage)
+			return
+		}
+		s.write(w, `<table><tr><th><strong>お預入れ合計</strong></th><td><strong>123,456 円</strong></td></tr></table>`)
+	case "/wpl/NBGate/i010001CT":
+		s.loggedIn = false
+		s.write(w, `<p>ログアウトしました</p>`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeServer) write(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=Shift_JIS")
+	fmt.Fprint(w, utils.ToSJIS(`<html><body>`+body+`</body></html>`))
+}
@@ -1,45 +1,102 @@
 package sbi
 
 import (
-	"io/ioutil"
+	"context"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/transform"
-
 	"github.com/binzume/gobanking/common"
 	"github.com/binzume/gobanking/utils"
+	"github.com/binzume/gobanking/utils/dom"
 )
 
 type Account struct {
 	common.BankAccount
 	balance   int64
 	client    *http.Client
+	log       *utils.Log
+	check     *utils.Checker
+	id        string
+	baseUrl   string
+	charset   string // charset to send the form
 	lastLogin time.Time
 }
 
+const bankID = "sbi"
 const BankCode = "0038"
 const BankName = "住信SBIネット銀行"
-const baseUrl = "https://www.netbk.co.jp/wpl/NBGate/"
+const defaultBaseUrl = "https://www.netbk.co.jp/wpl/NBGate/"
 
 type P map[string]string
 
-func Login(id, password string, options map[string]interface{}) (*Account, error) {
-	client, err := utils.NewHttpClient()
+func init() {
+	common.Register(bankID, driver{})
+	utils.SetRateLimit("netbk.co.jp", utils.RateLimit{Interval: time.Second, Burst: 3})
+}
+
+type driver struct{}
+
+func (driver) Login(ctx context.Context, id, password string, options map[string]interface{}) (common.Account, error) {
+	return LoginContext(ctx, id, password, options)
+}
+
+func (driver) Resume(ctx context.Context, s *common.Session, options map[string]interface{}) (common.Account, error) {
+	return Resume(ctx, s, options)
+}
+
+// Login is LoginContext without context. Parsing is lenient unless options["strict"] is specified.
+// The returned LegacyAccount has the old methods without context for compatibility.
+func Login(id, password string, options map[string]interface{}) (*LegacyAccount, error) {
+	a, err := LoginContext(context.Background(), id, password, utils.Lenient(options))
+	if a == nil {
+		return nil, err
+	}
+	return newLegacyAccount(a), err
+}
+
+func LoginContext(ctx context.Context, id, password string, options map[string]interface{}) (*Account, error) {
+	log := utils.NewLog(options, bankID)
+	client, err := utils.NewHttpClientFromOptions(options, log)
 	if err != nil {
 		return nil, err
 	}
-	a := &Account{client: client}
-	err = a.Login(id, password, options)
+	check := utils.NewChecker(options, bankID, log, utils.NewDiagnostics(options, bankID, log))
+	a := &Account{client: client, log: log, check: check, baseUrl: utils.OptionURL(options, utils.BaseURLKey, defaultBaseUrl)}
+	err = a.Login(ctx, id, password, options)
 	return a, err
 }
 
-func (a *Account) Login(id, password string, options map[string]interface{}) error {
-	_, err := a.post("i010101CT", P{
+// Resume restores a session saved by SaveSession().
+func Resume(ctx context.Context, s *common.Session, options map[string]interface{}) (*Account, error) {
+	log := utils.NewLog(options, bankID)
+	client, err := utils.NewHttpClientFromOptions(options, log)
+	if err != nil {
+		return nil, err
+	}
+	err = utils.ImportCookies(client, s.Cookies)
+	if err != nil {
+		return nil, err
+	}
+	check := utils.NewChecker(options, bankID, log, utils.NewDiagnostics(options, bankID, log))
+	a := &Account{client: client, log: log, check: check, id: s.ID, baseUrl: utils.OptionURL(options, utils.BaseURLKey, defaultBaseUrl)}
+	_, ok, err := a.loadTop(ctx)
+	if err == nil && !ok {
+		err = common.NewBankError(bankID, common.ErrSessionExpired, "balance not found")
+	}
+	return a, common.SessionRejected(bankID, err)
+}
+
+func (a *Account) SaveSession() (*common.Session, error) {
+	return &common.Session{Bank: bankID, ID: a.id, Cookies: utils.ExportCookies(a.client), SavedAt: time.Now()}, nil
+}
+
+func (a *Account) Login(ctx context.Context, id, password string, options map[string]interface{}) error {
+	a.id = id
+	a.log.AddSecret(id, password)
+	res, err := a.post(ctx, "i010101CT", P{
 		"userName":    id,
 		"loginPwdSet": password,
 		"x":           "0",
@@ -48,22 +105,40 @@ func (a *Account) Login(id, password string, options map[string]interface{}) err
 	if err != nil {
 		return err
 	}
+	if dom.Parse(res).Find(dom.Name("loginPwdSet")) != nil {
+		// the login page is returned without a message.
+		return common.NewBankError(bankID, common.ErrLoginRejected, "login page returned")
+	}
 
-	// top
-	res, err := a.get("i020101CT/DI02010100")
-	a.balance, _ = getMatchedInt(res, `(?s)<strong>お預入れ合計<\/strong>.*?<strong>([\d,]+)\s*円<\/strong>`)
+	res, ok, err := a.loadTop(ctx)
+	if err != nil || ok {
+		return err
+	}
+	check := a.check.Page("DI02010100", res)
+	check.Missing("お預入れ合計")
+	return check.Err()
+}
+
+// loadTop loads the top page. returns false if balance not found.
+func (a *Account) loadTop(ctx context.Context) (string, bool, error) {
+	res, err := a.get(ctx, "i020101CT/DI02010100")
+	if err != nil {
+		return res, false, err
+	}
+	balance, err := getMatchedInt(res, `(?s)<strong>お預入れ合計<\/strong>.*?<strong>([\d,]+)\s*円<\/strong>`)
+	a.balance = balance
 
 	// account infos
-	// res, err := a.get("i020401CT")
+	// res, err := a.get(ctx, "i020401CT")
 
 	a.BankCode = BankCode
 	a.BankName = BankName
 
-	return err
+	return res, err == nil, nil
 }
 
-func (a *Account) Logout() error {
-	_, err := a.get("i010001CT")
+func (a *Account) Logout(ctx context.Context) error {
+	_, err := a.get(ctx, "i010001CT")
 	return err
 }
 
@@ -71,52 +146,40 @@ func (a *Account) AccountInfo() *common.BankAccount {
 	return &a.BankAccount
 }
 
-func (a *Account) TotalBalance() (int64, error) {
+func (a *Account) TotalBalance(ctx context.Context) (int64, error) {
 	return a.balance, nil
 }
 
-func (a *Account) LastLogin() (time.Time, error) {
+func (a *Account) LastLogin(ctx context.Context) (time.Time, error) {
 	return a.lastLogin, nil
 }
 
-func (a *Account) Recent() ([]*common.Transaction, error) {
-	return nil, nil
-}
-
-func (a *Account) History(from, to time.Time) ([]*common.Transaction, error) {
-	// TODO
-	/*
-		res, err := a.get("i020201CT/PD/01/01/001/01")
-		// <form method="post" action="/wpl/NBGate" name="form0202_01_100">
-		res, err := a.post("", P{
-			"term":"01",
-			"dsplyTrmSpcfdYearFrom":fmt.Sprintf("%04d", from.Year()),
-			"dsplyTrmSpcfdMonthFrom":fmt.Sprintf("%02d", from.Month()),
-			"dsplyTrmSpcfdDayFrom":fmt.Sprintf("%02d", from.Day()),
-			"dsplyTrmSpcfdYearTo":fmt.Sprintf("%04d", to.Year()),
-			"dsplyTrmSpcfdMonthTo":fmt.Sprintf("%02d", to.Month()),
-			"dsplyTrmSpcfdDayTo":fmt.Sprintf("%02d", to.Day()),
-			}
-	*/
-	return nil, nil
-}
-
-// transfar api
-func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64) (common.TransferState, error) {
-	return nil, nil
-}
-
-func (a *Account) CommitTransfer(tr common.TransferState, pass2 string) (string, error) {
-	return "dummy", nil
-}
-
-func (a *Account) post(path string, params P) (string, error) {
+// TODO: History
+/*
+	res, err := a.get(ctx, "i020201CT/PD/01/01/001/01")
+	// <form method="post" action="/wpl/NBGate" name="form0202_01_100">
+	res, err := a.post(ctx, "", P{
+		"term":"01",
+		"dsplyTrmSpcfdYearFrom":fmt.Sprintf("%04d", from.Year()),
+		"dsplyTrmSpcfdMonthFrom":fmt.Sprintf("%02d", from.Month()),
+		"dsplyTrmSpcfdDayFrom":fmt.Sprintf("%02d", from.Day()),
+		"dsplyTrmSpcfdYearTo":fmt.Sprintf("%04d", to.Year()),
+		"dsplyTrmSpcfdMonthTo":fmt.Sprintf("%02d", to.Month()),
+		"dsplyTrmSpcfdDayTo":fmt.Sprintf("%02d", to.Day()),
+		}
+*/
+
+func (a *Account) post(ctx context.Context, path string, params P) (string, error) {
 	values := url.Values{}
 	for k, v := range params {
 		values.Set(k, v)
 	}
 
-	req, err := http.NewRequest("POST", baseUrl+path, strings.NewReader(values.Encode()))
+	body, err := utils.EncodeForm(values, a.charset)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseUrl+path, strings.NewReader(body))
 	if err != nil {
 		return "", err
 	}
@@ -124,9 +187,9 @@ func (a *Account) post(path string, params P) (string, error) {
 	return a.request(req)
 }
 
-func (a *Account) get(path string) (string, error) {
+func (a *Account) get(ctx context.Context, path string) (string, error) {
 
-	req, err := http.NewRequest("GET", baseUrl+path, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", a.baseUrl+path, nil)
 	if err != nil {
 		return "", err
 	}
@@ -140,12 +203,16 @@ func (a *Account) request(req *http.Request) (string, error) {
 	}
 	defer res.Body.Close()
 
-	b, err := ioutil.ReadAll(transform.NewReader(res.Body, japanese.ShiftJIS.NewDecoder()))
+	page, cs, err := utils.ReadBody(res)
 	if err != nil {
 		return "", err
 	}
-	// TODO check error
-	return string(b), err
+	doc := dom.Parse(page)
+	a.charset = utils.FormCharset(doc, cs)
+	if msg := doc.Find(dom.Class("errMsg")).Text(); msg != "" {
+		return page, common.NewBankError(bankID, common.ClassifyMessage(msg), msg)
+	}
+	return page, err
 }
 
 func getMatchedInt(htmlStr, reStr string) (int64, error) {
@@ -0,0 +1,77 @@
+package sbi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/binzume/gobanking/common"
+	"github.com/binzume/gobanking/utils"
+)
+
+func TestLogin(t *testing.T) {
+	s := newFakeServer(t)
+	ctx := context.Background()
+	acc, err := LoginContext(ctx, testID, testPassword, s.options())
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if balance, _ := acc.TotalBalance(ctx); balance != 123456 {
+		t.Errorf("unexpected balance: %v", balance)
+	}
+	if err := acc.Logout(ctx); err != nil {
+		t.Errorf("logout failed: %v", err)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	s := newFakeServer(t)
+	for _, strict := range []bool{true, false} {
+		opts := s.options()
+		opts[utils.StrictKey] = strict
+		_, err := LoginContext(context.Background(), testID, "wrong", opts)
+		if !errors.Is(err, common.ErrLoginRejected) {
+			t.Errorf("strict=%v: expected ErrLoginRejected: %v", strict, err)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := LoginContext(context.Background(), testID, testPassword, s.options())
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+	saved, err := acc.SaveSession()
+	if err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	// sessions are stored as JSON.
+	b, _ := json.Marshal(saved)
+	var session common.Session
+	if err := json.Unmarshal(b, &session); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := Resume(ctx, &session, s.options())
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	if resumed.AccountInfo().AccountNum != acc.AccountInfo().AccountNum {
+		t.Errorf("unexpected account: %+v", resumed.AccountInfo())
+	}
+	if balance, _ := resumed.TotalBalance(ctx); balance != 123456 {
+		t.Errorf("unexpected balance: %v", balance)
+	}
+
+	// banking.Resume logs in again if the session is expired.
+	s.expire()
+	if _, err := Resume(ctx, &session, s.options()); !errors.Is(err, common.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired: %v", err)
+	}
+	if _, err := LoginContext(context.Background(), testID, testPassword, s.options()); err != nil {
+		t.Errorf("login failed: %v", err)
+	}
+}
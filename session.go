@@ -0,0 +1,92 @@
+package banking
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+
+	"github.com/binzume/gobanking/common"
+)
+
+// SaveSession serializes the login session of acc. The result is encrypted with key (AES-GCM, any length).
+func SaveSession(acc common.Account, key []byte) ([]byte, error) {
+	saver, ok := acc.(common.SessionSaver)
+	if !ok {
+		return nil, common.ErrNotSupported
+	}
+	s, err := saver.SaveSession()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// Resume restores a session saved by SaveSession.
+// If data is empty or the bank rejects the session, it logs in again with c.
+func Resume(ctx context.Context, c *AccountConfig, data, key []byte) (common.Account, error) {
+	if len(data) == 0 {
+		return LoginContext(ctx, c)
+	}
+	s, err := openSession(data, key)
+	if err != nil {
+		return nil, err
+	}
+	if s.Bank != c.Bank || s.ID != c.Id {
+		return nil, errors.New("session for another account: " + s.Bank + ":" + s.ID)
+	}
+	d, ok := common.LookupDriver(c.Bank)
+	if !ok {
+		return nil, errors.New("unknown:" + c.Bank)
+	}
+	sd, ok := d.(common.SessionDriver)
+	if !ok {
+		return nil, common.ErrNotSupported
+	}
+	acc, err := sd.Resume(ctx, s, c.Options)
+	if errors.Is(err, common.ErrSessionExpired) {
+		return LoginContext(ctx, c)
+	}
+	return acc, err
+}
+
+func openSession(data, key []byte) (*common.Session, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("invalid session data")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	var s common.Session
+	err = json.Unmarshal(plain, &s)
+	return &s, err
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	k := sha256.Sum256(key)
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
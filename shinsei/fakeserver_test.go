@@ -0,0 +1,200 @@
+package shinsei
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/binzume/gobanking/utils"
+)
+
+const (
+	testID       = "4001234567"
+	testPassword = "password"
+	testPin      = "1234"
+	testPayee    = "1111111"
+)
+
+var testGrid = []string{"ABCDEFGHIJ", "KLMNOPQRST", "UVWXYZ0123", "456789abcd", "efghijklmn"}
+
+// fakeServer emulates Shinsei PowerDirect. Responses are JSON wrapped by "/*-secure-" and "*/".
+// The x-csrf-token is rotated by every adapter response.
+type fakeServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu         sync.Mutex
+	seq        int
+	auth       string
+	csrfToken  string
+	loggedIn   bool
+	preconfirm map[string]interface{}
+	committed  []string // committed transfer amounts
+	logins     int      // successful password logins
+
+	expireTop bool // the top page always requires authentication again
+	badTotal  bool // the total amount of the transfer confirmation doesn't match
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// options returns login options to use the fake server.
+func (s *fakeServer) options() map[string]interface{} {
+	return map[string]interface{}{utils.BaseURLKey: s.URL + "/SFC/app/"}
+}
+
+// expire invalidates the current session.
+func (s *fakeServer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loggedIn = false
+}
+
+type J map[string]interface{}
+
+func (s *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/SFC/app/")
+
+	switch path {
+	case "ShinseiAuthenticatorRealm/login_auth_request_url":
+		r.ParseForm()
+		if r.FormValue("fldUserID") != testID || r.FormValue("password") != testPassword {
+			s.write(w, J{"responseJSON": J{"authStatus": "failure", "errorMessage": "ログインIDまたはパスワードが正しくありません。"}})
+			return
+		}
+		s.seq++
+		s.auth = fmt.Sprintf("Bearer AUTH%d", s.seq)
+		s.csrfToken = fmt.Sprintf("CSRF%d", s.seq)
+		s.loggedIn = true
+		s.logins++
+		w.Header().Set("authorization", s.auth)
+		s.write(w, J{"responseJSON": J{"authStatus": "success", "token": s.csrfToken}})
+		return
+	case "ShinseiAuthenticatorRealm/logout_request_url":
+		s.loggedIn = false
+		s.write(w, J{})
+		return
+	}
+
+	if !s.loggedIn || r.Header.Get("authorization") != s.auth || (s.expireTop && path == "IFTP_TopAdapter/getBalanceSummaryAndStage") {
+		s.write(w, J{"challenges": J{"ShinseiAuthenticatorRealm": J{"authStatus": "required"}}})
+		return
+	}
+	if r.Header.Get("x-csrf-token") != s.csrfToken {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if path == "IFCM_CommonAdapter/securityConnect" {
+		s.write(w, J{"userId": "U0001", "attributes": J{"lastLoginTime": "2020/01/02 03:04:05"}})
+		return
+	}
+
+	var req struct {
+		Param map[string]interface{} `json:"requestParam"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, ok := s.adapter(path, req.Param)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.seq++
+	s.csrfToken = fmt.Sprintf("CSRF%d", s.seq)
+	s.write(w, J{"header": J{"newToken": s.csrfToken}, "responseParam": res})
+}
+
+func (s *fakeServer) adapter(path string, p map[string]interface{}) (J, bool) {
+	switch path {
+	case "IFCM_CommonAdapter/validateToken":
+		return J{}, true
+	case "IFTP_TopAdapter/getBalanceSummaryAndStage":
+		return J{
+			"summary":           J{"responseParam": J{"totalCredit": "1500000", "customerName": "新生　太郎", "customerNameKana": "ｼﾝｾｲ ﾀﾛｳ"}},
+			"mutualFundBalance": J{"responseParam": J{"yenEqui": "300000"}},
+			"branchFetch":       J{"responseParam": J{"branchName": "本店", "branchCode": "400"}},
+		}, true
+	case "IFTP_TopAdapter/getAccountsBalanceAndActivity":
+		return J{"activity": J{"responseParam": activity("2020/01/01", "2020/01/03")}}, true
+	case "IFAI_AccountAdapter/getCasaAccountActivitySpecificPeriod":
+		if p["accountNo"] != testID || p["type"] != "1" || len(fmt.Sprint(p["fromDate"])) != 8 {
+			return J{"activity": J{"errorInfo": J{"statusMessage": "照会期間が正しくありません"}}}, true
+		}
+		return J{"activity": J{"responseParam": activity(slashDate(p["fromDate"]), slashDate(p["toDate"]))}}, true
+	case "IFCM_CommonAdapter/getAccountInformationListDisplay":
+		return J{"accountOverviewAPIParm": J{"responseParam": J{"savingsDetails": []J{
+			{"currency": "JPY", "accountNo": testID, "balance": "1500000"},
+			{"currency": "USD", "accountNo": "4009999999", "balance": "123.45"},
+		}}}}, true
+	case "IFTR_TransferAdapter/getTransferBeneficiaryList":
+		return J{"beneficiaryListAPIParam": J{"responseParam": J{"details": []J{{
+			"beneficiaryAccountNo": testPayee, "beneficiaryName": "ﾃｽﾄ ﾀﾛｳ", "beneficiaryAccountType": "1",
+			"bankCode": "9999", "bankNameKanji": "テスト銀行", "bankNameKana": "ﾃｽﾄ",
+			"branchCode": "001", "branchNameKanji": "本店", "branchNameKana": "ﾎﾝﾃﾝ",
+		}}}}}, true
+	case "IFTR_TransferAdapter/registerPreconfirmation":
+		if p["beneficiaryAccountNo"] != testPayee || p["senderAccountNo"] != testID {
+			return J{"preconfirm": J{"errorInfo": J{"statusMessage": "振込先が存在しません"}}}, true
+		}
+		amount := fmt.Sprintf("%.0f", p["amount"]) // number
+		if amount == "2000000" {
+			return J{"preconfirm": J{"errorInfo": J{"statusMessage": "残高不足です"}}}, true
+		}
+		s.preconfirm = J{"amount": amount, "fee": "0", "totalAmount": amount, "transactionDate": "2020/01/10"}
+		if s.badTotal {
+			s.preconfirm["totalAmount"] = "9" + amount
+		}
+		return J{"preconfirm": J{"responseParam": s.preconfirm}}, true
+	case "IFCM_CommonAdapter/getCallengeGridPosition":
+		return J{"gridChallengeApiResponse": J{"responseParam": J{"challenge1": "A0", "challenge2": "C2", "challenge3": "J4"}}}, true
+	case "IFTR_TransferAdapter/registerConfirmation":
+		if s.preconfirm == nil || p["pin"] != testPin || p["amount"] != s.preconfirm["amount"] ||
+			p["gridChallengeValue1"] != "A" || p["gridChallengeValue2"] != "W" || p["gridChallengeValue3"] != "n" {
+			return J{"confirmApiResponse": J{"errorInfo": J{"statusMessage": "暗証番号が正しくありません"}}}, true
+		}
+		s.committed = append(s.committed, fmt.Sprint(p["amount"]))
+		s.preconfirm = nil
+		return J{"confirmApiResponse": J{"responseParam": J{"txnReferenceNo": fmt.Sprintf("TX%04d", len(s.committed))}}}, true
+	}
+	return nil, false
+}
+
+// slashDate converts "20200131" in requests to "2020/01/31" in responses.
+func slashDate(v interface{}) string {
+	d := fmt.Sprint(v)
+	if len(d) != 8 {
+		return d
+	}
+	return d[:4] + "/" + d[4:6] + "/" + d[6:]
+}
+
+// activity returns transactions in newest first order.
+func activity(from, to string) J {
+	return J{
+		"accountNo":      testID,
+		"currentBalance": "1500000",
+		"activityDetails": []J{
+			{"postingDate": to, "valueDate": to, "description": "振込 シンセイ　ハナコ", "txnReferenceNo": "REF2", "debit": "", "credit": "10,000", "balance": "1,500,000"},
+			{"postingDate": from, "valueDate": from, "description": "ATM", "txnReferenceNo": "REF1", "debit": "5,000", "credit": "", "balance": "1,490,000"},
+		},
+	}
+}
+
+func (s *fakeServer) write(w http.ResponseWriter, v interface{}) {
+	b, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	fmt.Fprintf(w, "/*-secure-\n%s*/", b)
+}
@@ -0,0 +1,39 @@
+package shinsei
+
+import (
+	"testing"
+
+	"github.com/binzume/gobanking/common"
+	"github.com/binzume/gobanking/utils"
+	"github.com/binzume/gobanking/utils/golden"
+)
+
+// newParser returns an Account to parse responses in strict mode without a session.
+func newParser() *Account {
+	log := utils.NewLog(nil, bankID)
+	return &Account{log: log, check: utils.NewChecker(nil, bankID, log, nil)}
+}
+
+func TestGoldenSummary(t *testing.T) {
+	a := newParser()
+	if err := a.parseSummary([]byte(golden.Load(t, "getBalanceSummaryAndStage.json"))); err != nil {
+		t.Fatal(err)
+	}
+	golden.Assert(t, "getBalanceSummaryAndStage.golden.json", struct {
+		Account          common.BankAccount `json:"account"`
+		Balance          int64              `json:"balance"`
+		FundBalance      int64              `json:"fund_balance"`
+		CustomerNameKana string             `json:"customer_name_kana"`
+	}{a.BankAccount, a.balance, a.fundBalance, a.customerNameKana})
+}
+
+func TestGoldenActivity(t *testing.T) {
+	a := newParser()
+	if err := a.parseActivity([]byte(golden.Load(t, "getAccountsBalanceAndActivity.json"))); err != nil {
+		t.Fatal(err)
+	}
+	golden.Assert(t, "getAccountsBalanceAndActivity.golden.json", struct {
+		AccountNo string                `json:"account_no"`
+		Recent    []*common.Transaction `json:"recent"`
+	}{a.mainAccountNo, a.recentTransaction})
+}
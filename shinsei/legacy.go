@@ -0,0 +1,47 @@
+package shinsei
+
+import (
+	"context"
+
+	"github.com/binzume/gobanking/common"
+)
+
+// LegacyAccount is returned by Login for compatibility. Methods don't take context.Context and use context.Background().
+// Common methods are provided by common.Legacy. Use LoginContext in new code.
+type LegacyAccount struct {
+	common.Legacy
+	*common.BankAccount
+	acc *Account
+}
+
+func newLegacyAccount(a *Account) *LegacyAccount {
+	return &LegacyAccount{Legacy: common.Legacy{Account: a}, BankAccount: &a.BankAccount, acc: a}
+}
+
+func (a *LegacyAccount) Refresh() error {
+	return a.acc.Refresh(context.Background())
+}
+
+func (a *LegacyAccount) MainBalance() int64 {
+	return a.acc.MainBalance()
+}
+
+func (a *LegacyAccount) FundBalance() int64 {
+	return a.acc.FundBalance()
+}
+
+func (a *LegacyAccount) GetAccountsBalanceAndActivity() error {
+	return a.acc.GetAccountsBalanceAndActivity(context.Background())
+}
+
+func (a *LegacyAccount) NewFxTransfer(fromCur, toCur string, amount float32, pin string) (*common.TransferQuote, error) {
+	return a.acc.NewFxTransfer(context.Background(), fromCur, toCur, amount, pin)
+}
+
+func (a *LegacyAccount) UpdateFxTransfer(tr *common.TransferQuote) error {
+	return a.acc.UpdateFxTransfer(context.Background(), tr)
+}
+
+func (a *LegacyAccount) CommitFxTransfer(tr *common.TransferQuote) (string, error) {
+	return a.acc.CommitFxTransfer(context.Background(), tr)
+}
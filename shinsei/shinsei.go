@@ -1,12 +1,11 @@
 package shinsei
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"time"
 
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -19,7 +18,14 @@ import (
 type Account struct {
 	common.BankAccount
 
-	client *http.Client
+	client    *http.Client
+	log       *utils.Log
+	diag      *utils.Diagnostics
+	check     *utils.Checker
+	id        string
+	baseUrl   string
+	relogin   *utils.Relogin
+	keepalive utils.Keepalive
 
 	auth          string
 	csrfToken     string
@@ -36,16 +42,82 @@ type Account struct {
 }
 
 type activityResponse struct {
-	AccountNo       string `json:"accountNo"`
-	CurrentBalance  string `json:"currentBalance"`
-	ActivityDetails []*struct {
-		PostingDate    string `json:"postingDate"`
-		Balance        int64  `json:"balance,string"`
-		Description    string `json:"description"`
-		TxnReferenceNo string `json:"txnReferenceNo"`
-		Debit          string `json:"debit"`
-		Credit         string `json:"credit"`
-	} `json:"activityDetails"`
+	AccountNo       string            `json:"accountNo"`
+	CurrentBalance  string            `json:"currentBalance"`
+	ActivityDetails []*activityDetail `json:"activityDetails"`
+}
+
+type activityDetail struct {
+	PostingDate    string `json:"postingDate"`
+	ValueDate      string `json:"valueDate"`
+	Balance        string `json:"balance"`
+	Description    string `json:"description"`
+	TxnReferenceNo string `json:"txnReferenceNo"`
+	Debit          string `json:"debit"`
+	Credit         string `json:"credit"`
+
+	raw map[string]interface{}
+}
+
+func (d *activityDetail) UnmarshalJSON(b []byte) error {
+	type plain activityDetail
+	if err := json.Unmarshal(b, (*plain)(d)); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &d.raw)
+}
+
+func (r *activityResponse) transactions(cur common.Currency, check *utils.PageCheck) []*common.Transaction {
+	var trs []*common.Transaction
+	for _, tr := range r.ActivityDetails {
+		date, err := time.Parse("2006/01/02", tr.PostingDate)
+		if err != nil {
+			check.Invalid("postingDate", tr.PostingDate)
+		}
+		valueDate, err := time.Parse("2006/01/02", tr.ValueDate)
+		if err != nil && tr.ValueDate != "" {
+			check.Invalid("valueDate", tr.ValueDate)
+		}
+		credit := checkMoney(check, "credit", tr.Credit, cur)
+		debit := checkMoney(check, "debit", tr.Debit, cur)
+		if tr.Credit == "" && tr.Debit == "" {
+			check.Missing("credit/debit")
+		}
+		kind, counterparty := common.ParseDescription(tr.Description)
+		raw := map[string]string{}
+		for k, v := range tr.raw {
+			if v != nil {
+				raw[k] = fmt.Sprint(v)
+			}
+		}
+		trs = append(trs, &common.Transaction{
+			Date:         date,
+			ValueDate:    valueDate,
+			Balance:      checkMoney(check, "balance", tr.Balance, cur),
+			Description:  tr.Description,
+			Amount:       credit.Add(debit.Neg()),
+			Kind:         kind,
+			ReferenceID:  tr.TxnReferenceNo,
+			Counterparty: counterparty,
+			Raw:          raw,
+		})
+	}
+	return trs
+}
+
+type transferState struct {
+	target     map[string]string
+	request    map[string]interface{}
+	preconfirm map[string]string
+	grid       map[string]string
+}
+
+type fxState struct {
+	params       map[string]interface{}
+	from         map[string]interface{}
+	to           map[string]interface{}
+	result       map[string]interface{}
+	exchangeRate interface{}
 }
 
 type authStatusResponse struct {
@@ -61,24 +133,99 @@ type securityConnectResponse struct {
 	Attributes map[string]interface{} `json:"attributes"`
 }
 
+const bankID = "shinsei"
 const BankCode = "0397"
 const BankName = "新生銀行"
 
-const baseUrl = "https://bk.web.sbishinseibank.co.jp/SFC/app/"
+const defaultBaseUrl = "https://bk.web.sbishinseibank.co.jp/SFC/app/"
 
 type P map[string]string
 
-func Login(id, password string, options map[string]interface{}) (*Account, error) {
-	client, err := utils.NewHttpClient()
+func init() {
+	common.Register(bankID, driver{})
+	utils.SetRateLimit("sbishinseibank.co.jp", utils.RateLimit{Interval: 500 * time.Millisecond, Burst: 4})
+}
+
+type driver struct{}
+
+func (driver) Login(ctx context.Context, id, password string, options map[string]interface{}) (common.Account, error) {
+	return LoginContext(ctx, id, password, options)
+}
+
+func (driver) Resume(ctx context.Context, s *common.Session, options map[string]interface{}) (common.Account, error) {
+	return Resume(ctx, s, options)
+}
+
+// Login is LoginContext without context. Parsing is lenient unless options["strict"] is specified.
+// The returned LegacyAccount has the old methods without context for compatibility.
+func Login(id, password string, options map[string]interface{}) (*LegacyAccount, error) {
+	a, err := LoginContext(context.Background(), id, password, utils.Lenient(options))
+	if a == nil {
+		return nil, err
+	}
+	return newLegacyAccount(a), err
+}
+
+func LoginContext(ctx context.Context, id, password string, options map[string]interface{}) (*Account, error) {
+	log := utils.NewLog(options, bankID)
+	client, err := utils.NewHttpClientFromOptions(options, log)
 	if err != nil {
 		return nil, err
 	}
-	a := &Account{client: client}
-	err = a.Login(id, password, options)
+	diag := utils.NewDiagnostics(options, bankID, log)
+	a := &Account{client: client, log: log, diag: diag, check: utils.NewChecker(options, bankID, log, diag)}
+	err = a.Login(ctx, id, password, options)
 	return a, err
 }
 
-func (a *Account) Login(id, password string, options map[string]interface{}) error {
+// Resume restores a session saved by SaveSession().
+func Resume(ctx context.Context, s *common.Session, options map[string]interface{}) (*Account, error) {
+	log := utils.NewLog(options, bankID)
+	client, err := utils.NewHttpClientFromOptions(options, log)
+	if err != nil {
+		return nil, err
+	}
+	err = utils.ImportCookies(client, s.Cookies)
+	if err != nil {
+		return nil, err
+	}
+	diag := utils.NewDiagnostics(options, bankID, log)
+	a := &Account{
+		client:           client,
+		log:              log,
+		diag:             diag,
+		check:            utils.NewChecker(options, bankID, log, diag),
+		auth:             s.Values["auth"],
+		csrfToken:        s.Values["csrfToken"],
+		mainAccountNo:    s.Values["mainAccountNo"],
+		customerNameKana: s.Values["customerNameKana"],
+	}
+	a.setOptions(s.ID, options)
+	err = a.query(ctx, "IFCM_CommonAdapter", "validateToken", nil, nil)
+	if err == nil {
+		err = a.Refresh(ctx)
+	}
+	return a, common.SessionRejected(bankID, err)
+}
+
+func (a *Account) SaveSession() (*common.Session, error) {
+	values := map[string]string{
+		"auth":             a.auth,
+		"csrfToken":        a.csrfToken,
+		"mainAccountNo":    a.mainAccountNo,
+		"customerNameKana": a.customerNameKana,
+	}
+	return &common.Session{Bank: bankID, ID: a.id, Cookies: utils.ExportCookies(a.client), Values: values, SavedAt: time.Now()}, nil
+}
+
+func (a *Account) setOptions(id string, options map[string]interface{}) {
+	a.id = id
+	a.baseUrl = utils.OptionURL(options, utils.BaseURLKey, defaultBaseUrl)
+	if len(id) > 3 {
+		a.BranchCode = id[0:3]
+		a.AccountNum = id[3:]
+	}
+	a.secureGrid = nil
 	if grid, ok := options["grid"].([]string); ok {
 		a.secureGrid = grid
 	}
@@ -87,8 +234,17 @@ func (a *Account) Login(id, password string, options map[string]interface{}) err
 			a.secureGrid = append(a.secureGrid, f.(string))
 		}
 	}
+	// also for resumed sessions.
+	a.log.AddSecret(id, a.AccountNum, a.mainAccountNo)
+	a.log.AddSecret(a.secureGrid...)
+}
+
+func (a *Account) Login(ctx context.Context, id, password string, options map[string]interface{}) error {
+	a.setOptions(id, options)
+	a.relogin = utils.NewRelogin(bankID, id, password, options, a.log)
+	a.log.AddSecret(password)
 
-	r, err := a.postForm("ShinseiAuthenticatorRealm/login_auth_request_url", P{
+	r, err := a.postForm(ctx, "ShinseiAuthenticatorRealm/login_auth_request_url", P{
 		"fldUserID":     id,
 		"password":      password,
 		"langCode":      "JAP",
@@ -106,38 +262,52 @@ func (a *Account) Login(id, password string, options map[string]interface{}) err
 		return err
 	}
 	if res.Res.AuthStatus != "success" {
-		return fmt.Errorf("invalid authStatus: %v", res.Res.AuthStatus)
+		msg := fmt.Sprintf("invalid authStatus: %v %v", res.Res.AuthStatus, res.Res.Error)
+		kind := common.ClassifyMessage(msg)
+		if kind == nil {
+			kind = common.ErrLoginRejected
+		}
+		return common.NewBankError(bankID, kind, msg)
 	}
 	a.csrfToken = res.Res.Token
 
 	var securityConnectRes securityConnectResponse
-	err = a.rawQuery("IFCM_CommonAdapter", "securityConnect", nil, &securityConnectRes)
+	err = a.rawQuery(ctx, "IFCM_CommonAdapter", "securityConnect", nil, &securityConnectRes)
 	if err != nil {
 		return err
 	}
 	if securityConnectRes.UserID == "" {
-		return fmt.Errorf("invalid response: %v", securityConnectRes)
+		return common.NewBankError(bankID, common.ErrLayoutChanged, fmt.Sprintf("invalid response: %v", securityConnectRes))
 	}
 	if lastLoginTime, ok := securityConnectRes.Attributes["lastLoginTime"].(string); ok {
 		a.lastLogin, _ = time.Parse("2006/01/02 15:04:05", lastLoginTime)
 	}
 
-	err = a.query("IFCM_CommonAdapter", "validateToken", nil, nil)
+	err = a.query(ctx, "IFCM_CommonAdapter", "validateToken", nil, nil)
 	if err != nil {
 		return err
 	}
 
-	a.BranchCode = id[0:3]
-	a.AccountNum = id[3:]
-	return a.Refresh()
+	// not a.Refresh(), which may login again.
+	return a.GetAccountsBalanceAndActivity(ctx)
 }
 
-func (a *Account) Refresh() error {
-	return a.GetAccountsBalanceAndActivity()
+// StartKeepalive validates the token while the session is idle.
+func (a *Account) StartKeepalive(ctx context.Context, interval time.Duration, onError func(error)) {
+	a.keepalive.Start(ctx, interval, func(ctx context.Context) error {
+		return a.query(ctx, "IFCM_CommonAdapter", "validateToken", nil, nil)
+	}, onError)
 }
 
-func (a *Account) Logout() error {
-	_, err := a.postForm("ShinseiAuthenticatorRealm/logout_request_url", P{})
+func (a *Account) Refresh(ctx context.Context) error {
+	return a.relogin.Retry(ctx, a, func() error {
+		return a.GetAccountsBalanceAndActivity(ctx)
+	})
+}
+
+func (a *Account) Logout(ctx context.Context) error {
+	a.keepalive.Stop()
+	_, err := a.postForm(ctx, "ShinseiAuthenticatorRealm/logout_request_url", P{})
 	return err
 }
 
@@ -145,7 +315,7 @@ func (a *Account) AccountInfo() *common.BankAccount {
 	return &a.BankAccount
 }
 
-func (a *Account) TotalBalance() (int64, error) {
+func (a *Account) TotalBalance(ctx context.Context) (int64, error) {
 	return a.balance + a.fundBalance, nil
 }
 
@@ -157,15 +327,19 @@ func (a *Account) FundBalance() int64 {
 	return a.fundBalance
 }
 
-func (a *Account) LastLogin() (time.Time, error) {
+func (a *Account) LastLogin(ctx context.Context) (time.Time, error) {
 	return a.lastLogin, nil
 }
 
-func (a *Account) Recent() ([]*common.Transaction, error) {
+func (a *Account) Recent(ctx context.Context) ([]*common.Transaction, error) {
 	return a.recentTransaction, nil
 }
 
-func (a *Account) History(from, to time.Time) ([]*common.Transaction, error) {
+func (a *Account) History(ctx context.Context, from, to time.Time) ([]*common.Transaction, error) {
+	return a.history(ctx, a.mainAccountNo, common.JPY, from, to)
+}
+
+func (a *Account) history(ctx context.Context, accountNo string, cur common.Currency, from, to time.Time) ([]*common.Transaction, error) {
 	fromStr := ""
 	toStr := ""
 	typ := "0"
@@ -178,40 +352,34 @@ func (a *Account) History(from, to time.Time) ([]*common.Transaction, error) {
 		typ = "1"
 	}
 	req := P{
-		"accountNo": a.mainAccountNo,
+		"accountNo": accountNo,
 		"type":      typ,
 		"fromDate":  fromStr,
 		"toDate":    toStr,
 	}
 
+	var raw json.RawMessage
+	err := a.relogin.Retry(ctx, a, func() error {
+		return a.query(ctx, "IFAI_AccountAdapter", "getCasaAccountActivitySpecificPeriod", req, &raw)
+	})
+	if err != nil {
+		return nil, err
+	}
 	var activityRes struct {
 		Activity struct {
 			Response activityResponse `json:"responseParam"`
 		} `json:"activity"`
 	}
-	err := a.query("IFAI_AccountAdapter", "getCasaAccountActivitySpecificPeriod", req, &activityRes)
-	if err != nil {
-		return nil, err
+	page := "IFAI_AccountAdapter/getCasaAccountActivitySpecificPeriod"
+	if err := json.Unmarshal(raw, &activityRes); err != nil {
+		return nil, a.layoutError(page, string(raw), err.Error())
 	}
-
-	var trs []*common.Transaction
-	for _, tr := range activityRes.Activity.Response.ActivityDetails {
-		date, _ := time.Parse("2006/01/02", tr.PostingDate)
-		credit, _ := strconv.ParseInt(tr.Credit, 10, 0)
-		debit, _ := strconv.ParseInt(tr.Debit, 10, 0)
-		trs = append(trs, &common.Transaction{
-			Date:        date,
-			Balance:     tr.Balance,
-			Description: tr.Description,
-			Amount:      credit - debit,
-		})
-	}
-
-	return trs, err
+	check := a.check.Page(page, string(raw))
+	trs := activityRes.Activity.Response.transactions(cur, check)
+	return trs, check.Err()
 }
 
-// transfar api
-func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64) (common.TransferState, error) {
+func (a *Account) getBeneficiaryList(ctx context.Context) ([]map[string]string, error) {
 	var res struct {
 		BeneficiaryList struct {
 			Response struct {
@@ -219,22 +387,54 @@ func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64
 			} `json:"responseParam"`
 		} `json:"beneficiaryListAPIParam"`
 	}
-	err := a.query("IFTR_TransferAdapter", "getTransferBeneficiaryList", nil, &res)
+	err := a.query(ctx, "IFTR_TransferAdapter", "getTransferBeneficiaryList", nil, &res)
+	return res.BeneficiaryList.Response.Details, err
+}
+
+func (a *Account) Payees(ctx context.Context) ([]*common.Payee, error) {
+	var details []map[string]string
+	err := a.relogin.Retry(ctx, a, func() (err error) {
+		details, err = a.getBeneficiaryList(ctx)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	var payees []*common.Payee
+	for _, detail := range details {
+		payees = append(payees, &common.Payee{
+			Name: detail["beneficiaryAccountNo"],
+			BankAccount: common.BankAccount{
+				BankName:   detail["bankNameKanji"],
+				BankCode:   detail["bankCode"],
+				BranchName: detail["branchNameKanji"],
+				BranchCode: detail["branchCode"],
+				AccountNum: detail["beneficiaryAccountNo"],
+				OwnerName:  detail["beneficiaryName"],
+			},
+		})
+	}
+	return payees, nil
+}
+
+// transfar api
+func (a *Account) NewTransferToRegisteredAccount(ctx context.Context, targetName string, amount int64) (*common.TransferQuote, error) {
+	details, err := a.getBeneficiaryList(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	var target map[string]string
-	for _, detail := range res.BeneficiaryList.Response.Details {
+	for _, detail := range details {
 		if detail["beneficiaryAccountNo"] == targetName {
 			target = detail
 		}
 	}
 	if target == nil {
-		return nil, fmt.Errorf("not found")
+		return nil, common.NewBankError(bankID, common.ErrPayeeNotRegistered, targetName)
 	}
 
-	utils.DebugLog("target: ", target)
+	a.log.Debug("transfer target", "target", target)
 
 	req := map[string]interface{}{
 		"senderAccountNo":        a.mainAccountNo,
@@ -253,7 +453,7 @@ func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64
 			Response map[string]string `json:"responseParam"`
 		} `json:"preconfirm"`
 	}
-	err = a.query("IFTR_TransferAdapter", "registerPreconfirmation", &req, &preconfirmRes)
+	err = a.query(ctx, "IFTR_TransferAdapter", "registerPreconfirmation", &req, &preconfirmRes)
 	if err != nil {
 		return nil, err
 	}
@@ -263,35 +463,63 @@ func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64
 			Response map[string]string `json:"responseParam"`
 		} `json:"gridChallengeApiResponse"`
 	}
-	err = a.query("IFCM_CommonAdapter", "getCallengeGridPosition", nil, &gridRes)
+	err = a.query(ctx, "IFCM_CommonAdapter", "getCallengeGridPosition", nil, &gridRes)
 	if err != nil {
 		return nil, err
 	}
 
 	preconfirm := preconfirmRes.Preconfirm.Response
-	amount, _ = strconv.ParseInt(preconfirm["amount"], 10, 0)
-	fee, _ := strconv.ParseInt(preconfirm["fee"], 10, 0)
-	return utils.TransferStateMap{
-		"target":     target,
-		"request":    req,
-		"preconfirm": preconfirm,
-		"grid":       gridRes.Result.Response,
-		"amount":     amount,
-		"fee":        fee,
+	check := a.check.Page("IFTR_TransferAdapter/registerPreconfirmation", fmt.Sprint(preconfirm))
+	if confirmed, err := strconv.ParseInt(preconfirm["amount"], 10, 64); err != nil || confirmed != amount {
+		check.Fatal("amount", preconfirm["amount"])
+	}
+	fee, err := strconv.ParseInt(preconfirm["fee"], 10, 64)
+	if err != nil {
+		check.Fatal("fee", preconfirm["fee"])
+	}
+	total, err := strconv.ParseInt(preconfirm["totalAmount"], 10, 64)
+	if err != nil || total != amount+fee {
+		check.Fatal("totalAmount", preconfirm["totalAmount"])
+	}
+	date, err := utils.ParseDate(preconfirm["transactionDate"])
+	if err != nil {
+		check.Invalid("transactionDate", preconfirm["transactionDate"])
+	}
+	if err := check.Err(); err != nil {
+		return nil, err
+	}
+	return &common.TransferQuote{
+		Amount:        common.Yen(amount),
+		Fee:           common.Yen(fee),
+		Total:         common.Yen(total),
+		ScheduledDate: date,
+		PayeeName:     target["beneficiaryName"],
+		PayeeBank:     target["bankNameKanji"],
+		PayeeBranch:   target["branchNameKanji"],
+		PayeeAccount:  target["beneficiaryAccountNo"],
+		State: &transferState{
+			target:     target,
+			request:    req,
+			preconfirm: preconfirm,
+			grid:       gridRes.Result.Response,
+		},
 	}, nil
 }
 
-func (a *Account) CommitTransfer(tr common.TransferState, pass2 string) (string, error) {
+func (a *Account) CommitTransfer(ctx context.Context, tr *common.TransferQuote, pass2 string) (string, error) {
 
 	if a.secureGrid == nil {
 		return "", fmt.Errorf("empty secure grid")
 	}
 
-	trmap := tr.(utils.TransferStateMap)
-	target := trmap["target"].(map[string]string)
-	preconfirm := trmap["preconfirm"].(map[string]string)
-	transfarReq := trmap["request"].(map[string]interface{})
-	gridChallenge := trmap["grid"].(map[string]string)
+	st, ok := tr.State.(*transferState)
+	if !ok {
+		return "", fmt.Errorf("invalid paramter type: tr")
+	}
+	target := st.target
+	preconfirm := st.preconfirm
+	transfarReq := st.request
+	gridChallenge := st.grid
 	req := map[string]interface{}{
 		"beneficiaryAdd":         1,
 		"senderName":             transfarReq["senderName"],
@@ -327,7 +555,7 @@ func (a *Account) CommitTransfer(tr common.TransferState, pass2 string) (string,
 			Param map[string]string `json:"responseParam"`
 		} `json:"confirmApiResponse"`
 	}
-	err := a.query("IFTR_TransferAdapter", "registerConfirmation", &req, &confirmRes)
+	err := a.query(ctx, "IFTR_TransferAdapter", "registerConfirmation", &req, &confirmRes)
 	if err != nil {
 		return "", err
 	}
@@ -337,16 +565,80 @@ func (a *Account) CommitTransfer(tr common.TransferState, pass2 string) (string,
 
 func extractResponseParam(res map[string]interface{}) (interface{}, error) {
 	if res == nil || res["responseParam"] == nil {
-		return nil, fmt.Errorf("No response: %v", res)
+		return nil, common.NewBankError(bankID, common.ErrLayoutChanged, fmt.Sprintf("No response: %v", res))
 	}
-	if e, ok := res["errorInfo"].(map[string]interface{}); ok && len(e) > 0 {
-		if e["statusMessage"] != "SUCCESS" {
-			return res["responseParam"], fmt.Errorf("Error: %v", e)
+	if e, ok := res["errorInfo"].(map[string]interface{}); ok {
+		if err := checkErrorInfo(e); err != nil {
+			return res["responseParam"], err
 		}
 	}
 	return res["responseParam"], nil
 }
 
+func checkErrorInfo(e map[string]interface{}) error {
+	if len(e) == 0 || e["statusMessage"] == "SUCCESS" {
+		return nil
+	}
+	msg := fmt.Sprint(e)
+	return common.NewBankError(bankID, common.ClassifyMessage(msg), msg)
+}
+
+func (a *Account) getSavingsDetails(ctx context.Context) ([]map[string]interface{}, error) {
+	var accountListRes struct {
+		Overview struct {
+			Param struct {
+				SavingDetails []map[string]interface{} `json:"savingsDetails"`
+			} `json:"responseParam"`
+		} `json:"accountOverviewAPIParm"`
+	}
+
+	err := a.query(ctx, "IFCM_CommonAdapter", "getAccountInformationListDisplay", P{"getPatternFlg": ""}, &accountListRes)
+	return accountListRes.Overview.Param.SavingDetails, err
+}
+
+// Accounts returns savings accounts for each currency.
+func (a *Account) Accounts(ctx context.Context) ([]common.SubAccount, error) {
+	var savingsDetails []map[string]interface{}
+	err := a.relogin.Retry(ctx, a, func() (err error) {
+		savingsDetails, err = a.getSavingsDetails(ctx)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	accounts := []common.SubAccount{}
+	for _, detail := range savingsDetails {
+		sub := &subAccount{a: a, BankAccount: a.BankAccount, currency: common.Currency(fmt.Sprint(detail["currency"]))}
+		sub.accountNo, _ = detail["accountNo"].(string)
+		if len(sub.accountNo) > 3 {
+			sub.AccountNum = sub.accountNo[3:]
+		}
+		sub.balance = parseMoney(fmt.Sprint(detail["balance"]), sub.currency)
+		accounts = append(accounts, sub)
+	}
+	return accounts, nil
+}
+
+type subAccount struct {
+	common.BankAccount
+	a         *Account
+	accountNo string
+	currency  common.Currency
+	balance   common.Money
+}
+
+func (s *subAccount) AccountInfo() *common.BankAccount {
+	return &s.BankAccount
+}
+
+func (s *subAccount) Balance(ctx context.Context) (common.Money, error) {
+	return s.balance, nil
+}
+
+func (s *subAccount) History(ctx context.Context, from, to time.Time) ([]*common.Transaction, error) {
+	return s.a.history(ctx, s.accountNo, s.currency, from, to)
+}
+
 func findAccount(accounts []map[string]interface{}, cur string) map[string]interface{} {
 	for _, a := range accounts {
 		if a["currency"].(string) == cur {
@@ -356,35 +648,27 @@ func findAccount(accounts []map[string]interface{}, cur string) map[string]inter
 	return nil
 }
 
-func (a *Account) NewFxTransfer(fromCur, toCur string, amount float32, pin string) (common.TransferState, error) {
+func (a *Account) NewFxTransfer(ctx context.Context, fromCur, toCur string, amount float32, pin string) (*common.TransferQuote, error) {
 
-	err := a.query("IFCM_CommonAdapter", "validateToken", nil, nil)
+	err := a.query(ctx, "IFCM_CommonAdapter", "validateToken", nil, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	var accountListRes struct {
-		Overview struct {
-			Param struct {
-				SavingDetails []map[string]interface{} `json:"savingsDetails"`
-			} `json:"responseParam"`
-		} `json:"accountOverviewAPIParm"`
-	}
-
-	err = a.query("IFCM_CommonAdapter", "getAccountInformationListDisplay", P{"getPatternFlg": ""}, &accountListRes)
+	savingsDetails, err := a.getSavingsDetails(ctx)
 	if err != nil {
 		return nil, err
 	}
-	var fromAccount = findAccount(accountListRes.Overview.Param.SavingDetails, fromCur)
+	var fromAccount = findAccount(savingsDetails, fromCur)
 	if fromAccount == nil {
 		return nil, fmt.Errorf("No account for %v", fromCur)
 	}
-	var toAccount = findAccount(accountListRes.Overview.Param.SavingDetails, toCur)
+	var toAccount = findAccount(savingsDetails, toCur)
 	if toAccount == nil {
-		return nil, fmt.Errorf("No account for %v", toAccount)
+		return nil, fmt.Errorf("No account for %v", toCur)
 	}
 
-	err = a.query("IFCM_CommonAdapter", "checkAuthenticationStatus", P{"pin": pin}, nil)
+	err = a.query(ctx, "IFCM_CommonAdapter", "checkAuthenticationStatus", P{"pin": pin}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -399,19 +683,29 @@ func (a *Account) NewFxTransfer(fromCur, toCur string, amount float32, pin strin
 		"debitProductCode":  fromAccount["productCode"],
 	}
 
-	tr := utils.TransferStateMap{
-		"params": params,
-		"from":   fromAccount,
-		"to":     toAccount,
+	debit := parseMoney(strconv.FormatFloat(float64(amount), 'f', -1, 32), common.Currency(fromCur))
+	tr := &common.TransferQuote{
+		Amount:       debit,
+		Total:        debit,
+		PayeeName:    fmt.Sprint(toAccount["currency"]),
+		PayeeAccount: fmt.Sprint(toAccount["accountNo"]),
+		State: &fxState{
+			params: params,
+			from:   fromAccount,
+			to:     toAccount,
+		},
 	}
-	return tr, a.UpdateFxTransfer(tr)
+	return tr, a.UpdateFxTransfer(ctx, tr)
 }
 
-func (a *Account) UpdateFxTransfer(tr common.TransferState) error {
-	trmap := tr.(utils.TransferStateMap)
+func (a *Account) UpdateFxTransfer(ctx context.Context, tr *common.TransferQuote) error {
+	st, ok := tr.State.(*fxState)
+	if !ok {
+		return fmt.Errorf("invalid paramter type: tr")
+	}
 
 	var confirmRes map[string]map[string]interface{}
-	err := a.query("IFFD_FxAdapter", "confirmPreRegistrationForeignCurrencyDeposits", trmap["params"], &confirmRes)
+	err := a.query(ctx, "IFFD_FxAdapter", "confirmPreRegistrationForeignCurrencyDeposits", st.params, &confirmRes)
 	if err != nil {
 		return err
 	}
@@ -422,32 +716,54 @@ func (a *Account) UpdateFxTransfer(tr common.TransferState) error {
 	}
 
 	if r, ok := res.(map[string]interface{}); ok {
-		trmap["result"] = r
-		trmap["exchangeRate"] = r["exchangeRate"]
-		trmap["buySpread"] = r["buySpread"]
-		trmap["convertedAmount"] = r["convertedAmount"]
+		st.result = r
+		st.exchangeRate = r["exchangeRate"]
+		tr.Rate, _ = strconv.ParseFloat(fmt.Sprint(r["exchangeRate"]), 64)
+		tr.Converted = parseMoney(fmt.Sprint(r["convertedAmount"]), common.Currency(fmt.Sprint(st.to["currency"])))
 		return nil
 	}
-	return fmt.Errorf("Unexpected response %#v", confirmRes)
+	return common.NewBankError(bankID, common.ErrLayoutChanged, fmt.Sprintf("Unexpected response %#v", confirmRes))
 }
 
-func (a *Account) CommitFxTransfer(tr common.TransferState) (string, error) {
-	trmap := tr.(utils.TransferStateMap)
-	params := trmap["params"].(map[string]interface{})
-	params["exchangeRate"] = trmap["exchangeRate"]
+func (a *Account) CommitFxTransfer(ctx context.Context, tr *common.TransferQuote) (string, error) {
+	st, ok := tr.State.(*fxState)
+	if !ok {
+		return "", fmt.Errorf("invalid paramter type: tr")
+	}
+	params := st.params
+	params["exchangeRate"] = st.exchangeRate
 
-	err := a.query("IFFD_FxAdapter", "registerForeignCurrencyDeposits", params, nil)
+	err := a.query(ctx, "IFFD_FxAdapter", "registerForeignCurrencyDeposits", params, nil)
 	return "", err
 }
 
-func (a *Account) GetAccountsBalanceAndActivity() error {
+func (a *Account) GetAccountsBalanceAndActivity(ctx context.Context) error {
+	var raw json.RawMessage
+	err := a.query(ctx, "IFTP_TopAdapter", "getBalanceSummaryAndStage", nil, &raw)
+	if err != nil {
+		return err
+	}
+	if err := a.parseSummary(raw); err != nil {
+		return err
+	}
+
+	raw = nil
+	err = a.query(ctx, "IFTP_TopAdapter", "getAccountsBalanceAndActivity", nil, &raw)
+	if err != nil {
+		return err
+	}
+	return a.parseActivity(raw)
+}
+
+// parseSummary parses the responseParam of getBalanceSummaryAndStage.
+func (a *Account) parseSummary(raw json.RawMessage) error {
 	var summaryRes struct {
 		Summary struct {
 			Param struct {
-				FxCasaBalance  int64 `json:"fxCasaBalance,string"`
-				SavingsBalance int64 `json:"savingsBalance,string"`
-				YenTDBalance   int64 `json:"yenTDBalance,string"`
-				TotalCredit    int64 `json:"totalCredit,string"`
+				FxCasaBalance  int64  `json:"fxCasaBalance,string"`
+				SavingsBalance int64  `json:"savingsBalance,string"`
+				YenTDBalance   int64  `json:"yenTDBalance,string"`
+				TotalCredit    *int64 `json:"totalCredit,string"`
 
 				CustomerName      string `json:"customerName"`
 				CustomerNameKana  string `json:"customerNameKana"`
@@ -466,12 +782,20 @@ func (a *Account) GetAccountsBalanceAndActivity() error {
 			} `json:"responseParam"`
 		} `json:"branchFetch"`
 	}
-	err := a.query("IFTP_TopAdapter", "getBalanceSummaryAndStage", nil, &summaryRes)
-	if err != nil {
+	page := "IFTP_TopAdapter/getBalanceSummaryAndStage"
+	if err := json.Unmarshal(raw, &summaryRes); err != nil {
+		return a.layoutError(page, string(raw), err.Error())
+	}
+	check := a.check.Page(page, string(raw))
+	a.balance = 0
+	if summaryRes.Summary.Param.TotalCredit != nil {
+		a.balance = *summaryRes.Summary.Param.TotalCredit
+	} else {
+		check.Missing("totalCredit")
+	}
+	if err := check.Err(); err != nil {
 		return err
 	}
-
-	a.balance = summaryRes.Summary.Param.TotalCredit
 	a.fundBalance = summaryRes.FundBalance.Param.YenEqui
 	a.customerNameKana = summaryRes.Summary.Param.CustomerNameKana
 
@@ -479,50 +803,72 @@ func (a *Account) GetAccountsBalanceAndActivity() error {
 	a.BankName = BankName
 	a.BranchName = summaryRes.Branch.Param.BranchName
 	a.OwnerName = summaryRes.Summary.Param.CustomerName
+	return nil
+}
 
+// parseActivity parses the responseParam of getAccountsBalanceAndActivity. Recent transactions are in oldest first order.
+func (a *Account) parseActivity(raw json.RawMessage) error {
 	var accountsRes struct {
 		Activity struct {
 			Response activityResponse `json:"responseParam"`
 		} `json:"activity"`
 	}
-	err = a.query("IFTP_TopAdapter", "getAccountsBalanceAndActivity", nil, &accountsRes)
-	if err != nil {
-		return err
+	page := "IFTP_TopAdapter/getAccountsBalanceAndActivity"
+	if err := json.Unmarshal(raw, &accountsRes); err != nil {
+		return a.layoutError(page, string(raw), err.Error())
 	}
+	check := a.check.Page(page, string(raw))
 
 	a.mainAccountNo = accountsRes.Activity.Response.AccountNo
+	a.log.AddSecret(a.mainAccountNo)
 
-	var trs []*common.Transaction
-	for _, tr := range accountsRes.Activity.Response.ActivityDetails {
-		date, _ := time.Parse("2006/01/02", tr.PostingDate)
-		credit, _ := strconv.ParseInt(tr.Credit, 10, 0)
-		debit, _ := strconv.ParseInt(tr.Debit, 10, 0)
-		trs = append(trs, &common.Transaction{
-			Date:        date,
-			Balance:     tr.Balance,
-			Description: tr.Description,
-			Amount:      credit - debit,
-		})
-	}
+	trs := accountsRes.Activity.Response.transactions(common.JPY, check)
 	// reverse
 	for i, j := 0, len(trs)-1; i < j; i, j = i+1, j-1 {
 		trs[i], trs[j] = trs[j], trs[i]
 	}
 	a.recentTransaction = trs
-	return nil
+	return check.Err()
+}
+
+func parseMoney(s string, cur common.Currency) common.Money {
+	m, err := common.ParseMoney(s, cur)
+	if err != nil {
+		return common.Money{Currency: cur.OrDefault()}
+	}
+	return m
+}
+
+// layoutError returns an ErrLayoutChanged error with the snapshot of the response.
+func (a *Account) layoutError(page, doc, msg string) error {
+	return a.diag.Snapshot(common.NewBankError(bankID, common.ErrLayoutChanged, msg), page, doc)
+}
+
+// checkMoney parses s. An invalid value is recorded to check. "" is zero.
+func checkMoney(check *utils.PageCheck, field, s string, cur common.Currency) common.Money {
+	m, err := common.ParseMoney(s, cur)
+	if err != nil {
+		if s != "" {
+			check.Invalid(field, s)
+		}
+		return common.Money{Currency: cur.OrDefault()}
+	}
+	return m
 }
 
 func (a *Account) getgrid(pos string) string {
 	return string(a.secureGrid[int(pos[1]-'0')][int(pos[0]-'A')])
 }
 
-func (a *Account) post(path, reqBody, contentType string) ([]byte, error) {
-	req, err := http.NewRequest("POST", baseUrl+path, strings.NewReader(reqBody))
+func (a *Account) post(ctx context.Context, path, reqBody, contentType string) ([]byte, error) {
+	a.keepalive.Lock()
+	defer a.keepalive.Unlock()
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseUrl+path, strings.NewReader(reqBody))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", contentType)
-	req.Header.Set("Referer", baseUrl)
+	req.Header.Set("Referer", a.baseUrl)
 	if a.auth != "" {
 		req.Header.Set("authorization", a.auth)
 	}
@@ -539,26 +885,40 @@ func (a *Account) post(path, reqBody, contentType string) ([]byte, error) {
 	if res.Header.Get("authorization") != "" {
 		a.auth = res.Header.Get("authorization")
 	}
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return nil, common.NewBankError(bankID, common.ErrSessionExpired, res.Status)
+	}
+
+	body, _, err := utils.ReadBody(res)
+	a.log.Debug("request", "path", path, "body", reqBody)
+	a.log.Debug("response", "path", path, "status", res.StatusCode, "body", body)
+	r := []byte(strings.TrimSuffix(strings.TrimPrefix(body, "/*-secure-"), "*/"))
 
-	b, err := ioutil.ReadAll(res.Body)
-	utils.DebugLog("params: ", reqBody)
-	utils.DebugLog("response:", string(b))
-	return bytes.TrimSuffix(bytes.TrimPrefix(b, []byte("/*-secure-")), []byte("*/")), err
+	// rotate the token before releasing the lock. the next request (e.g. keepalive) must use the new one.
+	var header struct {
+		Header struct {
+			NewToken string `json:"newToken"`
+		} `json:"header"`
+	}
+	if json.Unmarshal(r, &header) == nil && header.Header.NewToken != "" {
+		a.csrfToken = header.Header.NewToken
+	}
+	return r, err
 }
 
-func (a *Account) postForm(path string, params P) ([]byte, error) {
+func (a *Account) postForm(ctx context.Context, path string, params P) ([]byte, error) {
 	values := url.Values{}
 	for k, v := range params {
 		values.Set(k, v)
 	}
-	return a.post(path, values.Encode(), "application/x-www-form-urlencoded; charset=UTF-8")
+	return a.post(ctx, path, values.Encode(), "application/x-www-form-urlencoded; charset=UTF-8")
 }
 
-func (a *Account) postJson(path string, reqJson string) ([]byte, error) {
-	return a.post(path, reqJson, "application/json; charset=UTF-8")
+func (a *Account) postJson(ctx context.Context, path string, reqJson string) ([]byte, error) {
+	return a.post(ctx, path, reqJson, "application/json; charset=UTF-8")
 }
 
-func (a *Account) rawQuery(adapter, procedure string, req interface{}, res interface{}) error {
+func (a *Account) rawQuery(ctx context.Context, adapter, procedure string, req interface{}, res interface{}) error {
 	parametersStr := ""
 	if req != nil {
 		reqJSON, err := json.Marshal(req)
@@ -568,33 +928,55 @@ func (a *Account) rawQuery(adapter, procedure string, req interface{}, res inter
 		parametersStr = string(reqJSON)
 	}
 
-	r, err := a.postJson(adapter+"/"+procedure, parametersStr)
+	r, err := a.postJson(ctx, adapter+"/"+procedure, parametersStr)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(r, res)
+	if err := json.Unmarshal(r, res); err != nil {
+		return a.layoutError(adapter+"/"+procedure, string(r), err.Error())
+	}
+	return nil
 }
 
-func (a *Account) query(adapter, procedure string, req interface{}, res interface{}) error {
+func (a *Account) query(ctx context.Context, adapter, procedure string, req interface{}, res interface{}) error {
 	var result struct {
-		Response *json.RawMessage       `json:"responseParam"`
-		Headers  map[string]interface{} `json:"header"`
-		AuthInfo map[string]interface{} `json:"WL-Authentication-Success,omitempty"`
+		Response  *json.RawMessage       `json:"responseParam"`
+		AuthInfo  map[string]interface{} `json:"WL-Authentication-Success,omitempty"`
+		AuthFail  map[string]interface{} `json:"WL-Authentication-Failure,omitempty"`
+		Challenge map[string]interface{} `json:"challenges,omitempty"`
 	}
-	err := a.rawQuery(adapter, procedure, map[string]interface{}{"requestParam": req}, &result)
+	var raw json.RawMessage
+	err := a.rawQuery(ctx, adapter, procedure, map[string]interface{}{"requestParam": req}, &raw)
 	if err != nil {
 		return err
 	}
-	if token, ok := result.Headers["newToken"].(string); ok {
-		a.csrfToken = token
+	err = json.Unmarshal(raw, &result)
+	if err != nil {
+		return a.layoutError(adapter+"/"+procedure, string(raw), err.Error())
+	}
+	if result.AuthFail != nil || result.Challenge != nil {
+		// authentication is required again.
+		return common.NewBankError(bankID, common.ErrSessionExpired, fmt.Sprintf("%s/%s: authentication required", adapter, procedure))
+	}
+	if result.Response != nil {
+		var params map[string]struct {
+			ErrorInfo map[string]interface{} `json:"errorInfo"`
+		}
+		if json.Unmarshal(*result.Response, &params) == nil {
+			for _, p := range params {
+				if err := checkErrorInfo(p.ErrorInfo); err != nil {
+					return err
+				}
+			}
+		}
 	}
 	if res != nil {
 		if result.Response == nil {
-			return fmt.Errorf("Unexpected response %v", result)
+			return a.layoutError(adapter+"/"+procedure, string(raw), "responseParam not found")
 		}
 		err = json.Unmarshal(*result.Response, res)
 		if err != nil {
-			return err
+			return a.layoutError(adapter+"/"+procedure, string(raw), err.Error())
 		}
 	}
 	return nil
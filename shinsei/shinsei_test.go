@@ -0,0 +1,303 @@
+package shinsei
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binzume/gobanking/common"
+	"github.com/binzume/gobanking/utils"
+)
+
+func login(s *fakeServer, password string, options map[string]interface{}) (*Account, error) {
+	opts := map[string]interface{}{
+		"grid": testGrid,
+	}
+	for k, v := range s.options() {
+		opts[k] = v
+	}
+	for k, v := range options {
+		opts[k] = v
+	}
+	return LoginContext(context.Background(), testID, password, opts)
+}
+
+func TestLogin(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+
+	info := acc.AccountInfo()
+	if info.AccountNum != "1234567" || info.BranchCode != "400" || info.BranchName != "本店" || info.OwnerName != "新生　太郎" {
+		t.Errorf("unexpected account info: %+v", info)
+	}
+	if balance, _ := acc.TotalBalance(ctx); balance != 1800000 {
+		t.Errorf("unexpected balance: %v", balance)
+	}
+	if last, _ := acc.LastLogin(ctx); !last.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected last login: %v", last)
+	}
+	recent, _ := acc.Recent(ctx)
+	if len(recent) != 2 || recent[0].Amount != common.Yen(-5000) || recent[1].Balance != common.Yen(1500000) || recent[1].ReferenceID != "REF2" {
+		t.Errorf("unexpected recent: %v", recent)
+	}
+
+	if err := acc.Logout(ctx); err != nil {
+		t.Errorf("logout failed: %v", err)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	s := newFakeServer(t)
+	_, err := login(s, "wrong", nil)
+	if !errors.Is(err, common.ErrLoginRejected) {
+		t.Errorf("expected ErrLoginRejected: %v", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+	trs, err := acc.History(ctx, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	if len(trs) != 2 || trs[0].Counterparty != "シンセイ ハナコ" || trs[0].Amount != common.Yen(10000) || !trs[0].Date.Equal(time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected history: %v", trs)
+	}
+
+	accounts, err := acc.Accounts(ctx)
+	if err != nil || len(accounts) != 2 {
+		t.Fatalf("unexpected accounts: %v %v", accounts, err)
+	}
+	if b, _ := accounts[1].Balance(ctx); b != (common.Money{Value: 12345, Currency: "USD"}) {
+		t.Errorf("unexpected balance: %v", b)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+
+	payees, err := acc.Payees(ctx)
+	if err != nil || len(payees) != 1 || payees[0].BankName != "テスト銀行" {
+		t.Fatalf("unexpected payees: %v %v", payees, err)
+	}
+	if _, err := acc.NewTransferToRegisteredAccount(ctx, "unknown", 1000); !errors.Is(err, common.ErrPayeeNotRegistered) {
+		t.Errorf("expected ErrPayeeNotRegistered: %v", err)
+	}
+	if _, err := acc.NewTransferToRegisteredAccount(ctx, testPayee, 2000000); !errors.Is(err, common.ErrInsufficientFunds) {
+		t.Errorf("expected ErrInsufficientFunds: %v", err)
+	}
+
+	tr, err := acc.NewTransferToRegisteredAccount(ctx, testPayee, 1000)
+	if err != nil {
+		t.Fatalf("failed to create transfer: %v", err)
+	}
+	if tr.Amount != common.Yen(1000) || tr.Total != common.Yen(1000) || tr.ScheduledDate.Day() != 10 {
+		t.Errorf("unexpected quote: %v", tr)
+	}
+	recptNo, err := acc.CommitTransfer(ctx, tr, testPin)
+	if err != nil || recptNo != "TX0001" {
+		t.Fatalf("failed to commit: %v %v", recptNo, err)
+	}
+	if len(s.committed) != 1 || s.committed[0] != "1000" {
+		t.Errorf("unexpected transfers: %v", s.committed)
+	}
+}
+
+func TestLogRedaction(t *testing.T) {
+	s := newFakeServer(t)
+	var logs []string
+	logger := common.LoggerFunc(func(level common.LogLevel, msg string, kv ...interface{}) {
+		logs = append(logs, utils.FormatLog(level, msg, kv...))
+	})
+	acc, err := login(s, testPassword, map[string]interface{}{utils.LoggerKey: logger})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+	tr, err := acc.NewTransferToRegisteredAccount(ctx, testPayee, 1000)
+	if err != nil {
+		t.Fatalf("failed to create transfer: %v", err)
+	}
+	if _, err := acc.CommitTransfer(ctx, tr, testPin); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	all := strings.Join(logs, "\n")
+	if !strings.Contains(all, "registerConfirmation") {
+		t.Errorf("request is not logged: %s", all)
+	}
+	for _, secret := range append([]string{testID, "1234567", testPassword, `\"pin\":\"` + testPin, "CSRF", "AUTH", testPayee}, testGrid...) {
+		if strings.Contains(all, secret) {
+			t.Errorf("%q is logged: %s", secret, all)
+		}
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	s.expire()
+	if err := acc.Refresh(context.Background()); !errors.Is(err, common.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired: %v", err)
+	}
+
+	acc, err = login(s, testPassword, map[string]interface{}{"relogin": true})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	s.expire()
+	if _, err := acc.Payees(context.Background()); err != nil {
+		t.Errorf("relogin failed: %v", err)
+	}
+}
+
+func TestReloginExpiredTopPage(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, map[string]interface{}{"relogin": true})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	// the page after login expires every time.
+	s.expireTop = true
+	if err := acc.Refresh(context.Background()); !errors.Is(err, common.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired: %v", err)
+	}
+	if s.logins != 2 {
+		t.Errorf("expected 1 relogin: %v", s.logins-1)
+	}
+
+	_, err = login(s, testPassword, map[string]interface{}{"relogin": true})
+	if !errors.Is(err, common.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired: %v", err)
+	}
+	if s.logins != 3 {
+		t.Errorf("login must not retry: %v", s.logins-2)
+	}
+}
+
+// denyGuard refuses all logins.
+type denyGuard struct{}
+
+func (g *denyGuard) Allow(bank, id string) error { return errors.New("denied") }
+
+func (g *denyGuard) Record(bank, id string, loginErr error) error { return nil }
+
+func TestReloginGuarded(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, map[string]interface{}{"relogin": true})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	utils.SetLoginGuard(&denyGuard{})
+	defer utils.SetLoginGuard(nil)
+
+	s.expire()
+	if _, err := acc.Payees(context.Background()); err == nil || err.Error() != "denied" {
+		t.Errorf("relogin must be checked by the guard: %v", err)
+	}
+	if s.logins != 1 {
+		t.Errorf("unexpected logins: %v", s.logins)
+	}
+}
+
+func TestTransferQuoteChanged(t *testing.T) {
+	s := newFakeServer(t)
+	s.badTotal = true
+	// mismatched amounts are errors even in lenient mode.
+	acc, err := login(s, testPassword, map[string]interface{}{utils.StrictKey: false})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	tr, err := acc.NewTransferToRegisteredAccount(context.Background(), testPayee, 1000)
+	var layoutErr *common.LayoutError
+	if tr != nil || !errors.As(err, &layoutErr) || layoutErr.Field != "totalAmount" {
+		t.Errorf("expected LayoutError: %v %v", tr, err)
+	}
+}
+
+func TestKeepaliveToken(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	// the token is rotated before the lock is released, so a keepalive ping never uses the old one.
+	if _, err := acc.postJson(context.Background(), "IFCM_CommonAdapter/validateToken", `{"requestParam":null}`); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if acc.csrfToken != s.csrfToken {
+		t.Errorf("token is not rotated: %v != %v", acc.csrfToken, s.csrfToken)
+	}
+}
+
+func TestResume(t *testing.T) {
+	s := newFakeServer(t)
+	acc, err := login(s, testPassword, nil)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	ctx := context.Background()
+	saved, err := acc.SaveSession()
+	if err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	// sessions are stored as JSON.
+	b, _ := json.Marshal(saved)
+	var session common.Session
+	if err := json.Unmarshal(b, &session); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs []string
+	opts := s.options()
+	opts["grid"] = testGrid
+	opts[utils.LoggerKey] = common.LoggerFunc(func(level common.LogLevel, msg string, kv ...interface{}) {
+		logs = append(logs, utils.FormatLog(level, msg, kv...))
+	})
+	resumed, err := Resume(ctx, &session, opts)
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	if balance, _ := resumed.TotalBalance(ctx); balance != 1800000 {
+		t.Errorf("unexpected balance: %v", balance)
+	}
+	if s.logins != 1 {
+		t.Errorf("logged in again: %d", s.logins)
+	}
+	all := strings.Join(logs, "\n")
+	for _, secret := range []string{testID, "1234567"} {
+		if strings.Contains(all, secret) || strings.Contains(resumed.log.Redact("id: "+secret), secret) {
+			t.Errorf("%q is not masked: %s", secret, all)
+		}
+	}
+
+	// banking.Resume logs in again if the session is expired.
+	s.expire()
+	if _, err := Resume(ctx, &session, opts); !errors.Is(err, common.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired: %v", err)
+	}
+	if _, err := login(s, testPassword, nil); err != nil {
+		t.Errorf("login failed: %v", err)
+	}
+}
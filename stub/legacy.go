@@ -0,0 +1,14 @@
+package stub
+
+import "github.com/binzume/gobanking/common"
+
+// LegacyAccount is returned by Login for compatibility. Methods don't take context.Context and use context.Background().
+// Common methods are provided by common.Legacy. Use LoginContext in new code.
+type LegacyAccount struct {
+	common.Legacy
+	*common.BankAccount
+}
+
+func newLegacyAccount(a *Account) *LegacyAccount {
+	return &LegacyAccount{Legacy: common.Legacy{Account: a}, BankAccount: &a.BankAccount}
+}
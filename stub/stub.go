@@ -1,6 +1,7 @@
 package stub
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -10,66 +11,157 @@ import (
 
 type Account struct {
 	common.BankAccount
-	options map[string]interface{}
+	options   map[string]interface{}
+	keepalive utils.Keepalive
 }
 
+const bankID = "stub"
 const BankCode = "9999"
 const BankName = "テスト銀行"
 
-func Login(id, password string, options map[string]interface{}) (*Account, error) {
+func init() {
+	common.Register(bankID, driver{})
+}
+
+type driver struct{}
+
+func (driver) Login(ctx context.Context, id, password string, options map[string]interface{}) (common.Account, error) {
+	return LoginContext(ctx, id, password, options)
+}
+
+func (driver) Resume(ctx context.Context, s *common.Session, options map[string]interface{}) (common.Account, error) {
+	return Resume(ctx, s, options)
+}
+
+// Login is LoginContext without context. The returned LegacyAccount has the old methods without context for compatibility.
+func Login(id, password string, options map[string]interface{}) (*LegacyAccount, error) {
+	a, err := LoginContext(context.Background(), id, password, options)
+	if a == nil {
+		return nil, err
+	}
+	return newLegacyAccount(a), err
+}
+
+func LoginContext(ctx context.Context, id, password string, options map[string]interface{}) (*Account, error) {
 	a := &Account{
-		BankAccount: common.BankAccount{BankCode: BankCode, BankName: BankName, BranchCode: "001", BranchName: "テスト支店", OwnerName: id},
+		BankAccount: common.BankAccount{BankCode: BankCode, BankName: BankName, BranchCode: "001", BranchName: "テスト支店", AccountNum: "0000001", OwnerName: id},
 	}
-	err := a.Login(id, password, options)
+	err := a.Login(ctx, id, password, options)
 	return a, err
 }
 
-func (a *Account) Login(id, password string, options map[string]interface{}) error {
+// Resume restores a session saved by SaveSession(). Session is expired if options["expired"] is true.
+func Resume(ctx context.Context, s *common.Session, options map[string]interface{}) (*Account, error) {
+	a := &Account{
+		BankAccount: common.BankAccount{BankCode: BankCode, BankName: BankName, BranchCode: "001", BranchName: "テスト支店", AccountNum: "0000001", OwnerName: s.ID},
+		options:     options,
+	}
+	if expired, _ := options["expired"].(bool); expired || s.Values["token"] != "dummy" {
+		return a, common.NewBankError(bankID, common.ErrSessionExpired, "session expired")
+	}
+	return a, nil
+}
+
+func (a *Account) SaveSession() (*common.Session, error) {
+	return &common.Session{Bank: bankID, ID: a.OwnerName, Values: map[string]string{"token": "dummy"}, SavedAt: time.Now()}, nil
+}
+
+func (a *Account) Login(ctx context.Context, id, password string, options map[string]interface{}) error {
 	if id == "" || password == "" {
-		return errors.New("login error")
+		return common.NewBankError(bankID, common.ErrLoginRejected, "login error")
 	}
 	a.options = options
 	return nil
 }
 
-func (a *Account) Logout() error {
+func (a *Account) Logout(ctx context.Context) error {
+	a.keepalive.Stop()
 	return nil
 }
 
+// StartKeepalive fails if options["expired"] is true.
+func (a *Account) StartKeepalive(ctx context.Context, interval time.Duration, onError func(error)) {
+	a.keepalive.Start(ctx, interval, func(ctx context.Context) error {
+		if expired, _ := a.options["expired"].(bool); expired {
+			return common.NewBankError(bankID, common.ErrSessionExpired, "session expired")
+		}
+		return nil
+	}, onError)
+}
+
 func (a *Account) AccountInfo() *common.BankAccount {
 	return &a.BankAccount
 }
 
-func (a *Account) TotalBalance() (int64, error) {
+func (a *Account) TotalBalance(ctx context.Context) (int64, error) {
 	return a.getInt("balance", 1234567), nil
 }
 
-func (a *Account) LastLogin() (time.Time, error) {
+func (a *Account) LastLogin(ctx context.Context) (time.Time, error) {
 	return time.Now(), nil
 }
 
-func (a *Account) Recent() ([]*common.Transaction, error) {
+func (a *Account) Recent(ctx context.Context) ([]*common.Transaction, error) {
 	base := time.Now().Truncate(time.Hour * 24).Add(-time.Hour * 24 * 7) // week ago today.
 	return []*common.Transaction{
-		&common.Transaction{Date: base, Amount: 123, Balance: 123, Description: "test"},
-		&common.Transaction{Date: base.Add(time.Hour * 48), Amount: 10000, Balance: 10123, Description: "test2"},
-		&common.Transaction{Date: time.Now().Truncate(time.Second), Amount: -5000, Balance: 5123, Description: "test..."},
+		&common.Transaction{Date: base, Amount: common.Yen(123), Balance: common.Yen(123), Description: "test", ReferenceID: "0001"},
+		&common.Transaction{Date: base.Add(time.Hour * 48), Amount: common.Yen(10000), Balance: common.Yen(10123), Description: "振込 テスト", Kind: common.KindTransfer, Counterparty: "テスト", ReferenceID: "0002"},
+		&common.Transaction{Date: time.Now().Truncate(time.Second), Amount: common.Yen(-5000), Balance: common.Yen(5123), Description: "test...", ReferenceID: "0003"},
 	}, nil
 }
 
-func (a *Account) History(from, to time.Time) ([]*common.Transaction, error) {
-	return a.Recent()
+func (a *Account) History(ctx context.Context, from, to time.Time) ([]*common.Transaction, error) {
+	return a.Recent(ctx)
+}
+
+func (a *Account) Accounts(ctx context.Context) ([]common.SubAccount, error) {
+	savings := &subAccount{a: a, BankAccount: a.BankAccount, balance: a.getInt("savings_balance", 1000000)}
+	savings.AccountNum = "0000002"
+	return []common.SubAccount{
+		&subAccount{a: a, BankAccount: a.BankAccount, balance: a.getInt("balance", 1234567)},
+		savings,
+	}, nil
+}
+
+type subAccount struct {
+	common.BankAccount
+	a       *Account
+	balance int64
+}
+
+func (s *subAccount) AccountInfo() *common.BankAccount {
+	return &s.BankAccount
+}
+
+func (s *subAccount) Balance(ctx context.Context) (common.Money, error) {
+	return common.Yen(s.balance), nil
+}
+
+func (s *subAccount) History(ctx context.Context, from, to time.Time) ([]*common.Transaction, error) {
+	return s.a.History(ctx, from, to)
 }
 
 // transfar api
-func (a *Account) NewTransferToRegisteredAccount(targetName string, amount int64) (common.TransferState, error) {
-	if amount == 0 || targetName == "" {
+func (a *Account) NewTransferToRegisteredAccount(ctx context.Context, targetName string, amount int64) (*common.TransferQuote, error) {
+	if targetName == "" {
+		return nil, common.NewBankError(bankID, common.ErrPayeeNotRegistered, targetName)
+	}
+	if amount == 0 {
 		return nil, errors.New("transfer error")
 	}
-	return utils.TransferStateMap{"fee": a.getInt("transfer_fee", 100), "amount": amount, "to": targetName}, nil
+	fee := a.getInt("transfer_fee", 100)
+	return &common.TransferQuote{
+		Amount:        common.Yen(amount),
+		Fee:           common.Yen(fee),
+		Total:         common.Yen(amount + fee),
+		ScheduledDate: time.Now().Truncate(time.Hour * 24),
+		ExpiresAt:     time.Now().Add(10 * time.Minute),
+		PayeeName:     targetName,
+		PayeeBank:     BankName,
+	}, nil
 }
 
-func (a *Account) CommitTransfer(tr common.TransferState, pass2 string) (string, error) {
+func (a *Account) CommitTransfer(ctx context.Context, tr *common.TransferQuote, pass2 string) (string, error) {
 	if pass2 == "" {
 		return "", errors.New("commit error")
 	}
@@ -0,0 +1,221 @@
+// Package cassette records HTTP interactions to a file and replays them for offline tests.
+//
+//	rec := cassette.NewRecorder(nil, &cassette.Redactor{Secrets: []string{id, password, accountNum}})
+//	acc, err := mizuho.Login(id, password, map[string]interface{}{"client": &utils.ClientOptions{Transport: rec}})
+//	...
+//	rec.Save("testdata/login.json")
+//
+//	rep, err := cassette.Load("testdata/login.json")
+//	acc, err := mizuho.Login(id, password, map[string]interface{}{"client": &utils.ClientOptions{Transport: rep}})
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrNoInteraction is returned by Replayer when all interactions are consumed.
+var ErrNoInteraction = errors.New("cassette: no more interactions")
+
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is saved as a string if it's valid UTF-8, otherwise as base64.
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string][]byte{"base64": b})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+	var m map[string][]byte
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*b = m["base64"]
+	return nil
+}
+
+// Recorder is a http.RoundTripper which records redacted interactions.
+type Recorder struct {
+	Transport http.RoundTripper // default: http.DefaultTransport
+	Redactor  *Redactor         // nil means DefaultRedactor
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+func NewRecorder(transport http.RoundTripper, redactor *Redactor) *Recorder {
+	return &Recorder{Transport: transport, Redactor: redactor}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = b
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	}
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+
+	redactor := r.Redactor
+	if redactor == nil {
+		redactor = DefaultRedactor
+	}
+	it := &Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    redactor.String(req.URL.String()),
+			Header: redactor.Header(req.Header),
+			Body:   redactor.Bytes(reqBody),
+		},
+		Response: Response{
+			StatusCode: res.StatusCode,
+			Header:     redactor.Header(res.Header),
+			Body:       redactor.Bytes(resBody),
+		},
+	}
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, it)
+	r.mu.Unlock()
+	return res, nil
+}
+
+// Cassette returns recorded interactions.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Cassette{Interactions: append([]*Interaction{}, r.cassette.Interactions...)}
+}
+
+// Save writes recorded interactions to the file.
+func (r *Recorder) Save(path string) error {
+	buf, err := json.MarshalIndent(r.Cassette(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0644)
+}
+
+// Replayer is a http.RoundTripper which returns recorded responses in order.
+// Requests must match the method, host and path of the recorded ones.
+type Replayer struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	pos      int
+}
+
+func NewReplayer(c *Cassette) *Replayer {
+	return &Replayer{cassette: c}
+}
+
+// Load reads a cassette saved by Recorder.Save.
+func Load(path string) (*Replayer, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return nil, err
+	}
+	return NewReplayer(&c), nil
+}
+
+func (p *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pos >= len(p.cassette.Interactions) {
+		return nil, ErrNoInteraction
+	}
+	it := p.cassette.Interactions[p.pos]
+	if err := match(&it.Request, req); err != nil {
+		return nil, fmt.Errorf("cassette: interaction %d: %w", p.pos, err)
+	}
+	p.pos++
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", it.Response.StatusCode, http.StatusText(it.Response.StatusCode)),
+		StatusCode:    it.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        it.Response.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(it.Response.Body)),
+		ContentLength: int64(len(it.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+func match(rec *Request, req *http.Request) error {
+	u, err := url.Parse(rec.URL)
+	if err != nil {
+		return err
+	}
+	if rec.Method != req.Method || u.Host != req.URL.Host || u.Path != req.URL.Path {
+		return fmt.Errorf("request mismatch: %s %s://%s%s, recorded %s %s://%s%s",
+			req.Method, req.URL.Scheme, req.URL.Host, req.URL.Path, rec.Method, u.Scheme, u.Host, u.Path)
+	}
+	return nil
+}
+
+// Remaining returns the number of interactions not replayed yet.
+func (p *Replayer) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.cassette.Interactions) - p.pos
+}
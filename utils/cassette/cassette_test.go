@@ -0,0 +1,116 @@
+package cassette
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/binzume/gobanking/utils"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t-session"})
+			w.Write([]byte(`<input type="hidden" name="POSTKEY" value="postkey123"><span>1234567</span>` + utils.ToSJIS("山田太郎")))
+		case "/api":
+			w.Write([]byte(`/*-secure-{"header":{"newToken":"tok456"},"accountNo":"1234567"}*/`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	rec := NewRecorder(nil, &Redactor{Secrets: []string{"myid", "1234567", "山田太郎"}})
+	client := &http.Client{Transport: rec}
+	res, err := client.PostForm(server.URL+"/login", url.Values{"txbCustNo": {"myid"}, "password": {"pass!word"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "postkey123") {
+		t.Errorf("recorder must not modify responses: %s", body)
+	}
+	res, err = client.Get(server.URL + "/api?token=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := rec.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := ioutil.ReadFile(path)
+	for _, secret := range []string{"myid", "pass!word", "pass%21word", "postkey123", "s3cr3t", "tok456", "1234567", "abc"} {
+		if bytes.Contains(saved, []byte(secret)) {
+			t.Errorf("%q is not redacted: %s", secret, saved)
+		}
+	}
+	if bytes.Contains(saved, []byte(utils.ToSJIS("山田太郎"))) {
+		t.Errorf("name is not redacted")
+	}
+
+	rep, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: rep}
+	res, err = client.PostForm(server.URL+"/login", url.Values{"txbCustNo": {"dummy"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), `name="POSTKEY" value="REDACTED"`) {
+		t.Errorf("unexpected body: %s", body)
+	}
+
+	_, err = client.Get(server.URL + "/other")
+	if err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("expected mismatch error: %v", err)
+	}
+	if _, err = client.Get(server.URL + "/api"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Get(server.URL + "/api"); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expected ErrNoInteraction: %v", err)
+	}
+}
+
+func TestDefaultRedactor(t *testing.T) {
+	// shinsei registerConfirmation
+	transfer := `{"requestParam":{"beneficiaryAdd":1,"senderAccountNo":"4001234567","beneficiaryAccountNo":"1111111",` +
+		`"accountNo":4001234567,"amount":"1000","pin":"1234","gridChallengeValue1":"A","gridChallengeValue2":"W","gridChallengeValue3":"n"}}`
+	// mizuho top page
+	page := `<span id="txtAccNo">7654321</span>円<input type="hidden" value="KEY0" name="POSTKEY">` +
+		`<input type="hidden" name="_TOKEN" value="TOKEN1">`
+	form := "pin=1234&senderAccountNo=4001234567&gridChallengeValue2=W&amount=1000"
+
+	got := DefaultRedactor.String(transfer)
+	for _, secret := range []string{"4001234567", "1111111", `"1234"`, `"A"`, `"W"`, `"n"`} {
+		if strings.Contains(got, secret) {
+			t.Errorf("%q is not redacted: %s", secret, got)
+		}
+	}
+	if !strings.Contains(got, `"amount":"1000"`) || !strings.Contains(got, `"accountNo":"REDACTED"`) {
+		t.Errorf("unexpected result: %s", got)
+	}
+	got = DefaultRedactor.String(page)
+	for _, secret := range []string{"7654321", "KEY0", "TOKEN1"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("%q is not redacted: %s", secret, got)
+		}
+	}
+	if got = DefaultRedactor.String(form); got != "pin=REDACTED&senderAccountNo=REDACTED&gridChallengeValue2=REDACTED&amount=1000" {
+		t.Errorf("unexpected result: %s", got)
+	}
+}
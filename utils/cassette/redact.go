@@ -0,0 +1,122 @@
+package cassette
+
+import (
+	"bytes"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/binzume/gobanking/utils"
+)
+
+const Redacted = "REDACTED"
+
+// Redactor removes credentials from recorded interactions.
+type Redactor struct {
+	Secrets []string // literal strings such as ids, passwords, account numbers and names.
+	Params  []string // form, query, JSON or input names, or element ids whose values are removed. "*" matches any name characters.
+	Headers []string // headers whose values are removed.
+
+	once  sync.Once
+	rules []rule
+	lits  [][]byte
+}
+
+// DefaultParams are the names of credentials and tokens used by the bundled banks.
+var DefaultParams = []string{
+	// credentials
+	"password", "fldUserID", "txbCustNo", "PASSWD_LoginPwdInput", "txbTestWord",
+	"PASSWD_ScndPwd1", "PASSWD_ScndPwd2", "PASSWD_ScndPwd3", "PASSWD_ScndPwd4",
+	"LOGIN:USER_ID", "LOGIN:LOGIN_PASSWORD", "INPUT_FORM:SECRET_WORD", "SECURITY_BOARD:USER_PASSWORD",
+	"loginId", "loginPwd", "transactionPassword", "pin", "gridChallengeValue*",
+	// account numbers
+	"accountNo", "*AccountNo", "txtAccNo",
+	// tokens
+	"POSTKEY", "_TOKEN", "javax.faces.ViewState", "INPUT_FORM:TOKEN", "SECURITY_BOARD:TOKEN", "newToken", "token",
+}
+
+var DefaultHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Csrf-Token"}
+
+// DefaultRedactor removes DefaultParams and DefaultHeaders.
+var DefaultRedactor = &Redactor{}
+
+type rule struct {
+	re   *regexp.Regexp
+	repl []byte
+}
+
+func (r *Redactor) init() {
+	r.once.Do(func() {
+		params := append(append([]string{}, DefaultParams...), r.Params...)
+		for _, p := range params {
+			q := namePattern(p, func(s string) string { return s })
+			e := namePattern(p, url.QueryEscape)
+			add := func(re, repl string) {
+				r.rules = append(r.rules, rule{regexp.MustCompile(re), []byte(repl)})
+			}
+			add(`((?:^|[?&])(?:`+q+`|`+e+`)=)[^&\s"]*`, "${1}"+Redacted)                                      // form, query
+			add(`("`+q+`"\s*:\s*")(?:[^"\\]|\\.)*"`, "${1}"+Redacted+`"`)                                     // JSON string
+			add(`("`+q+`"\s*:\s*)-?\d[\d.]*`, "${1}\""+Redacted+`"`)                                          // JSON number
+			add(`(<input\b[^>]*?\bname=["']`+q+`["'][^>]*?\bvalue=["'])[^"']*`, "${1}"+Redacted)              // input
+			add(`(<input\b[^>]*?\bvalue=["'])[^"']*(["'][^>]*?\bname=["']`+q+`["'])`, "${1}"+Redacted+"${2}") // input (value first)
+			add(`(\bid=["']`+q+`["'][^>]*>)[^<]*`, "${1}"+Redacted)                                           // element text
+		}
+		for _, s := range r.Secrets {
+			if s == "" {
+				continue
+			}
+			for _, v := range []string{s, url.QueryEscape(s), utils.ToSJIS(s), url.QueryEscape(utils.ToSJIS(s))} {
+				r.lits = append(r.lits, []byte(v))
+			}
+		}
+	})
+}
+
+// namePattern returns a regexp matching the escaped name. "*" matches any name characters.
+func namePattern(name string, escape func(string) string) string {
+	parts := strings.Split(name, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(escape(p))
+	}
+	return strings.Join(parts, `[\w.:%-]*`)
+}
+
+// Bytes returns redacted b.
+func (r *Redactor) Bytes(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	r.init()
+	for _, rule := range r.rules {
+		b = rule.re.ReplaceAll(b, rule.repl)
+	}
+	for _, lit := range r.lits {
+		b = bytes.Replace(b, lit, []byte(Redacted), -1)
+	}
+	return b
+}
+
+func (r *Redactor) String(s string) string {
+	return string(r.Bytes([]byte(s)))
+}
+
+// Header returns a redacted copy of h.
+func (r *Redactor) Header(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range append(append([]string{}, DefaultHeaders...), r.Headers...) {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		for i := range h[name] {
+			h[name][i] = Redacted
+		}
+	}
+	for name, values := range h {
+		for i, v := range values {
+			values[i] = r.String(v)
+		}
+		h[name] = values
+	}
+	return h
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/binzume/gobanking/utils/dom"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+)
+
+// DefaultCharset is used if the charset of a page is unknown.
+const DefaultCharset = "Shift_JIS"
+
+// ReadBody reads the body of res and decodes it to UTF-8.
+// The charset is determined by BOM, Content-Type, <meta charset> or UTF-8 validity, otherwise DefaultCharset.
+// Returns the canonical name of the charset. e.g. "shift_jis", "utf-8"
+func ReadBody(res *http.Response) (string, string, error) {
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", "", err
+	}
+	enc, name, certain := charset.DetermineEncoding(b, res.Header.Get("Content-Type"))
+	if !certain && name == "windows-1252" {
+		// not declared.
+		enc, name = charset.Lookup(DefaultCharset)
+	}
+	s, _, err := transform.Bytes(enc.NewDecoder(), b)
+	return string(s), name, err
+}
+
+// FormCharset returns the first charset in accept-charset of forms in doc, or def if not specified.
+func FormCharset(doc *dom.Node, def string) string {
+	form := doc.Find(func(n *dom.Node) bool { return n.Attr("accept-charset") != "" })
+	for _, cs := range strings.FieldsFunc(form.Attr("accept-charset"), func(r rune) bool { return r == ' ' || r == ',' }) {
+		if enc, name := charset.Lookup(cs); enc != nil {
+			return name
+		}
+	}
+	return def
+}
+
+// EncodeForm encodes values in the charset ("" means DefaultCharset) like url.Values.Encode.
+// Characters not in the charset are sent as HTML character references like browsers.
+func EncodeForm(values url.Values, cs string) (string, error) {
+	if cs == "" {
+		cs = DefaultCharset
+	}
+	enc, _ := charset.Lookup(cs)
+	if enc == nil {
+		return values.Encode(), nil
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		ek, err := enc.NewEncoder().String(k)
+		if err != nil {
+			return "", err
+		}
+		for _, v := range values[k] {
+			ev, err := enc.NewEncoder().String(v)
+			if err != nil {
+				return "", err
+			}
+			if sb.Len() > 0 {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(url.QueryEscape(ek))
+			sb.WriteByte('=')
+			sb.WriteString(url.QueryEscape(ev))
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ClientOptionsKey is the key of ClientOptions in the options of Login.
+// The value is a *ClientOptions or a JSON object like:
+//
+//	{"proxy": "http://proxy:8080", "timeout": "30s", "user_agent": "Mozilla/5.0 ...", "ca_file": "ca.pem"}
+const ClientOptionsKey = "client"
+
+// ClientOptions configures the HTTP client used by banks. Zero values mean defaults.
+type ClientOptions struct {
+	Transport http.RoundTripper // default: http.DefaultTransport. Proxy and TLSConfig are ignored if set.
+	Proxy     *url.URL          // default: from environment variables
+	TLSConfig *tls.Config
+	Timeout   time.Duration // per request
+	UserAgent string        // must starts with Mozilla/...
+}
+
+func (o *ClientOptions) transport() (http.RoundTripper, error) {
+	if o.Transport != nil {
+		return o.Transport, nil
+	}
+	if o.Proxy == nil && o.TLSConfig == nil {
+		return http.DefaultTransport, nil
+	}
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("http.DefaultTransport is not *http.Transport")
+	}
+	t := base.Clone()
+	if o.Proxy != nil {
+		t.Proxy = http.ProxyURL(o.Proxy)
+	}
+	if o.TLSConfig != nil {
+		t.TLSClientConfig = o.TLSConfig
+	}
+	return t, nil
+}
+
+// ParseClientOptions returns options[ClientOptionsKey]. Returns nil if not specified.
+func ParseClientOptions(options map[string]interface{}) (*ClientOptions, error) {
+	switch v := options[ClientOptionsKey].(type) {
+	case nil:
+		return nil, nil
+	case *ClientOptions:
+		return v, nil
+	case ClientOptions:
+		return &v, nil
+	case map[string]interface{}:
+		return parseClientOptionsJSON(v)
+	default:
+		return nil, fmt.Errorf("invalid %s option: %T", ClientOptionsKey, v)
+	}
+}
+
+func parseClientOptionsJSON(m map[string]interface{}) (*ClientOptions, error) {
+	opts := &ClientOptions{}
+	if s, ok := m["proxy"].(string); ok && s != "" {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		opts.Proxy = u
+	}
+	switch t := m["timeout"].(type) {
+	case string:
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, err
+		}
+		opts.Timeout = d
+	case float64:
+		opts.Timeout = time.Duration(t * float64(time.Second))
+	}
+	if s, ok := m["user_agent"].(string); ok {
+		opts.UserAgent = s
+	}
+	if path, ok := m["ca_file"].(string); ok && path != "" {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", path)
+		}
+		opts.TLSConfig = &tls.Config{RootCAs: pool}
+	}
+	return opts, nil
+}
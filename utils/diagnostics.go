@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/binzume/gobanking/common"
+)
+
+// DiagnosticsDirKey is the option key of a directory to save pages which couldn't be parsed.
+// Saved pages are sanitized by the Log of the account, but check them before sharing.
+const DiagnosticsDirKey = "diagnostics_dir"
+
+var unsafeFileChars = regexp.MustCompile(`[^\w.-]+`)
+
+// Diagnostics saves snapshots of pages whose expected elements were not found.
+// A nil *Diagnostics does nothing.
+type Diagnostics struct {
+	dir  string
+	bank string
+	log  *Log
+}
+
+// NewDiagnostics returns a Diagnostics if options[DiagnosticsDirKey] is specified. Otherwise returns nil.
+func NewDiagnostics(options map[string]interface{}, bank string, log *Log) *Diagnostics {
+	dir, _ := options[DiagnosticsDirKey].(string)
+	if dir == "" {
+		return nil
+	}
+	return &Diagnostics{dir: dir, bank: bank, log: log}
+}
+
+// Dump writes the sanitized page to "<bank>_<page>_<timestamp>.(html|json)" and returns the path.
+func (d *Diagnostics) Dump(page, doc string) (string, error) {
+	if d == nil {
+		return "", nil
+	}
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return "", err
+	}
+	ext := ".html"
+	if s := strings.TrimSpace(doc); strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[") {
+		ext = ".json"
+	}
+	name := fmt.Sprintf("%s_%s_%s%s", d.bank, unsafeFileChars.ReplaceAllString(page, "_"), time.Now().Format("20060102-150405.000"), ext)
+	path := filepath.Join(d.dir, name)
+	return path, ioutil.WriteFile(path, []byte(d.log.Redact(doc)), 0600)
+}
+
+// Snapshot dumps the page if err is a common.ErrLayoutChanged error (*common.BankError or *common.LayoutError),
+// and sets the path to the error.
+func (d *Diagnostics) Snapshot(err error, page, doc string) error {
+	var bankErr *common.BankError
+	var layoutErr *common.LayoutError
+	var snapshot *string
+	if errors.As(err, &layoutErr) {
+		snapshot = &layoutErr.Snapshot
+	} else if errors.As(err, &bankErr) {
+		snapshot = &bankErr.Snapshot
+	}
+	if d == nil || snapshot == nil || *snapshot != "" || !errors.Is(err, common.ErrLayoutChanged) {
+		return err
+	}
+	path, dumpErr := d.Dump(page, doc)
+	if dumpErr != nil {
+		d.log.Warn("failed to save snapshot", "page", page, "error", dumpErr)
+		return err
+	}
+	d.log.Info("snapshot saved", "page", page, "path", path)
+	*snapshot = path
+	return err
+}
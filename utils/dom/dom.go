@@ -0,0 +1,304 @@
+// Package dom finds elements, form values and table cells in HTML pages.
+//
+//	doc := dom.Parse(page)
+//	balance := doc.Find(dom.ID("txtCrntBal")).Text()
+//	token := doc.InputValue("_TOKEN")
+//	for _, row := range doc.Find(dom.Tag("table")).Rows() { ... }
+//
+// Methods of a nil *Node return zero values, so lookups can be chained without checks.
+package dom
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Node is an element (or the document root) of a page.
+type Node struct {
+	n *html.Node
+}
+
+// Matcher reports whether an element matches.
+type Matcher func(n *Node) bool
+
+// Parse parses an HTML page. Returns nil if failed.
+func Parse(doc string) *Node {
+	n, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return nil
+	}
+	return &Node{n}
+}
+
+// Tag matches elements by tag name. e.g. "table"
+func Tag(tag string) Matcher {
+	return func(n *Node) bool { return n.n.Data == tag }
+}
+
+// Attr matches elements which have the attribute.
+func Attr(key, value string) Matcher {
+	return func(n *Node) bool {
+		v, ok := n.attr(key)
+		return ok && v == value
+	}
+}
+
+// ID matches elements by id.
+func ID(id string) Matcher {
+	return Attr("id", id)
+}
+
+// IDPrefix matches elements whose id starts with prefix. e.g. "txtDate_" for txtDate_001, txtDate_002, ...
+func IDPrefix(prefix string) Matcher {
+	return func(n *Node) bool {
+		v, ok := n.attr("id")
+		return ok && strings.HasPrefix(v, prefix)
+	}
+}
+
+// Name matches elements by name attribute.
+func Name(name string) Matcher {
+	return Attr("name", name)
+}
+
+// Class matches elements which have the class.
+func Class(class string) Matcher {
+	return func(n *Node) bool {
+		for _, c := range strings.Fields(n.Attr("class")) {
+			if c == class {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ContainsText matches elements whose own text (excluding child elements) contains s.
+func ContainsText(s string) Matcher {
+	return func(n *Node) bool { return strings.Contains(n.ownText(), s) }
+}
+
+// MatchText matches elements whose own text (excluding child elements) matches re.
+func MatchText(re *regexp.Regexp) Matcher {
+	return func(n *Node) bool { return re.MatchString(strings.TrimSpace(n.ownText())) }
+}
+
+// And matches elements which match all of ms.
+func And(ms ...Matcher) Matcher {
+	return func(n *Node) bool {
+		for _, m := range ms {
+			if !m(n) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// next returns the next node of n in document order, not going out of root.
+func next(n, root *html.Node) *html.Node {
+	if n.FirstChild != nil {
+		return n.FirstChild
+	}
+	for ; n != nil && n != root; n = n.Parent {
+		if n.NextSibling != nil {
+			return n.NextSibling
+		}
+	}
+	return nil
+}
+
+func (n *Node) each(m Matcher, root *html.Node, f func(*Node) bool) {
+	if n == nil {
+		return
+	}
+	for c := next(n.n, root); c != nil; c = next(c, root) {
+		if c.Type == html.ElementNode && m(&Node{c}) && !f(&Node{c}) {
+			return
+		}
+	}
+}
+
+// Find returns the first descendant element matching m.
+func (n *Node) Find(m Matcher) *Node {
+	var found *Node
+	if n != nil {
+		n.each(m, n.n, func(c *Node) bool {
+			found = c
+			return false
+		})
+	}
+	return found
+}
+
+// FindAll returns descendant elements matching m in document order.
+func (n *Node) FindAll(m Matcher) []*Node {
+	var found []*Node
+	if n != nil {
+		n.each(m, n.n, func(c *Node) bool {
+			found = append(found, c)
+			return true
+		})
+	}
+	return found
+}
+
+// Following returns the first element matching m after the start tag of n in document order.
+// e.g. the value cell of a label.
+func (n *Node) Following(m Matcher) *Node {
+	var found *Node
+	n.each(m, nil, func(c *Node) bool {
+		found = c
+		return false
+	})
+	return found
+}
+
+// Closest returns n or the nearest ancestor element matching m.
+func (n *Node) Closest(m Matcher) *Node {
+	if n == nil {
+		return nil
+	}
+	for c := n.n; c != nil; c = c.Parent {
+		if c.Type == html.ElementNode && m(&Node{c}) {
+			return &Node{c}
+		}
+	}
+	return nil
+}
+
+func (n *Node) attr(key string) (string, bool) {
+	if n == nil {
+		return "", false
+	}
+	for _, a := range n.n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// Attr returns the attribute value, or "" if not exists.
+func (n *Node) Attr(key string) string {
+	v, _ := n.attr(key)
+	return v
+}
+
+// Text returns the text content of n without leading and trailing spaces.
+func (n *Node) Text() string {
+	if n == nil {
+		return ""
+	}
+	var sb strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				sb.WriteString(c.Data)
+			} else if c.Type == html.ElementNode && c.Data != "script" && c.Data != "style" {
+				f(c)
+			}
+		}
+	}
+	f(n.n)
+	return strings.TrimSpace(sb.String())
+}
+
+func (n *Node) ownText() string {
+	var sb strings.Builder
+	for c := n.n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			sb.WriteString(c.Data)
+		}
+	}
+	return sb.String()
+}
+
+// Cells returns td and th elements of a tr.
+func (n *Node) Cells() []*Node {
+	if n == nil {
+		return nil
+	}
+	var cells []*Node
+	for c := n.n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (c.Data == "td" || c.Data == "th") {
+			cells = append(cells, &Node{c})
+		}
+	}
+	return cells
+}
+
+// Rows returns cells of each row in a table. Rows of nested tables are not included.
+func (n *Node) Rows() [][]*Node {
+	var rows [][]*Node
+	for _, tr := range n.FindAll(Tag("tr")) {
+		if t := tr.Closest(Tag("table")); t != nil && t.n == n.n {
+			rows = append(rows, tr.Cells())
+		}
+	}
+	return rows
+}
+
+func isControl(n *Node) bool {
+	tag := n.n.Data
+	return tag == "input" || tag == "select" || tag == "textarea" || tag == "button"
+}
+
+func (n *Node) value() string {
+	if n == nil {
+		return ""
+	}
+	switch n.n.Data {
+	case "select":
+		opts := n.FindAll(Tag("option"))
+		for _, o := range opts {
+			if _, ok := o.attr("selected"); ok {
+				return o.optionValue()
+			}
+		}
+		if len(opts) > 0 {
+			return opts[0].optionValue()
+		}
+		return ""
+	case "textarea":
+		return n.Text()
+	}
+	return n.Attr("value")
+}
+
+func (n *Node) optionValue() string {
+	if v, ok := n.attr("value"); ok {
+		return v
+	}
+	return n.Text()
+}
+
+// InputValue returns the value of the first form control (input, select, textarea) named name.
+func (n *Node) InputValue(name string) string {
+	return n.Find(And(isControl, Name(name))).value()
+}
+
+// FormValues returns values of the form controls in n to be submitted.
+// Unchecked checkboxes and radio buttons, and buttons are excluded.
+func (n *Node) FormValues() map[string]string {
+	values := map[string]string{}
+	for _, c := range n.FindAll(isControl) {
+		name := c.Attr("name")
+		if name == "" || c.n.Data == "button" {
+			continue
+		}
+		switch strings.ToLower(c.Attr("type")) {
+		case "submit", "button", "image", "reset":
+			continue
+		case "checkbox", "radio":
+			if _, ok := c.attr("checked"); !ok {
+				continue
+			}
+		}
+		values[name] = c.value()
+	}
+	return values
+}
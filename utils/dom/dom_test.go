@@ -0,0 +1,80 @@
+package dom
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+const testPage = `<html><head><base href="https://example.com/servlet/"></head><body>
+<div class="header main"><span
+  class="name" id="txtName">山田&nbsp;太郎</span>様</div>
+<table id="summary">
+ <tr><th>残高</th><td><span class="amount">1,234</span>円</td></tr>
+ <tr><th>内訳</th><td><table><tr><td>nested</td></tr></table></td></tr>
+</table>
+<form name="FORM" action="/next.do">
+ <input type="hidden" value="abc" name="_TOKEN">
+ <input type="checkbox" name="chk" value="on">
+ <input type="checkbox" name="chk2" value="on" checked>
+ <input type="submit" name="btn" value="次へ">
+ <select name="sel"><option value="0">A</option><option value="1" selected>B</option></select>
+ <textarea name="msg"> hello </textarea>
+</form>
+</body></html>`
+
+func TestFind(t *testing.T) {
+	doc := Parse(testPage)
+	if name := doc.Find(ID("txtName")).Text(); name != "山田\u00a0太郎" {
+		t.Errorf("unexpected text: %q", name)
+	}
+	if doc.Find(And(Tag("div"), Class("main"))) == nil || doc.Find(Class("mai")) != nil {
+		t.Errorf("class not matched")
+	}
+	if base := doc.Find(Tag("base")).Attr("href"); base != "https://example.com/servlet/" {
+		t.Errorf("unexpected base: %v", base)
+	}
+	if div := doc.Find(ID("txtName")).Closest(Tag("div")); div.Attr("class") != "header main" {
+		t.Errorf("unexpected closest: %v", div.Attr("class"))
+	}
+	amount := MatchText(regexp.MustCompile(`^[\d,]+$`))
+	if v := doc.Find(ContainsText("残高")).Following(amount).Text(); v != "1,234" {
+		t.Errorf("unexpected value: %q", v)
+	}
+	if n := len(doc.FindAll(Tag("tr"))); n != 3 {
+		t.Errorf("unexpected rows: %v", n)
+	}
+
+	// nil safe
+	if v := doc.Find(ID("notfound")).Find(Tag("span")).Following(Tag("td")).Text(); v != "" {
+		t.Errorf("unexpected value: %q", v)
+	}
+	if Parse("").Find(Tag("td")) != nil {
+		t.Errorf("empty page has td")
+	}
+}
+
+func TestRows(t *testing.T) {
+	rows := Parse(testPage).Find(ID("summary")).Rows()
+	if len(rows) != 2 || len(rows[0]) != 2 || rows[0][1].Text() != "1,234円" || rows[1][0].Text() != "内訳" {
+		t.Errorf("unexpected rows: %v", rows)
+	}
+}
+
+func TestForm(t *testing.T) {
+	doc := Parse(testPage)
+	if v := doc.InputValue("_TOKEN"); v != "abc" {
+		t.Errorf("unexpected value: %q", v)
+	}
+	if v := doc.InputValue("sel"); v != "1" {
+		t.Errorf("unexpected value: %q", v)
+	}
+	if v := doc.InputValue("notfound"); v != "" {
+		t.Errorf("unexpected value: %q", v)
+	}
+	values := doc.Find(Name("FORM")).FormValues()
+	expected := map[string]string{"_TOKEN": "abc", "chk2": "on", "sel": "1", "msg": "hello"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("unexpected values: %v", values)
+	}
+}
@@ -0,0 +1,54 @@
+// Package golden compares parsed results with expected JSON files in testdata.
+//
+//	page := golden.Load(t, "top.html")
+//	trs := parse(page)
+//	golden.Assert(t, "top.golden.json", trs)
+//
+// Run `go test ./mizuho -update` to regenerate the golden files after changing parsers, and review the diff.
+// Pages saved by the diagnostics_dir option can be added to testdata as they are.
+package golden
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// Load returns the content of testdata/name.
+func Load(t testing.TB, name string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// Assert compares got encoded as JSON with testdata/name. The file is rewritten if -update is specified.
+func Assert(t testing.TB, name string, got interface{}) {
+	t.Helper()
+	b, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = append(b, '\n')
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create)", err)
+	}
+	if !bytes.Equal(bytes.ReplaceAll(expected, []byte("\r\n"), []byte("\n")), b) {
+		t.Errorf("%s doesn't match (run with -update to regenerate):\n%s", path, b)
+	}
+}
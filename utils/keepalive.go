@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Keepalive serializes requests of an account and sends a ping while the session is idle.
+// The zero value is ready to use.
+type Keepalive struct {
+	mu     sync.Mutex // held while sending a request
+	last   time.Time
+	cmu    sync.Mutex
+	cancel context.CancelFunc
+}
+
+// Lock must be held while sending requests.
+func (k *Keepalive) Lock() {
+	k.mu.Lock()
+}
+
+// Unlock releases the lock and records the time of the last request.
+func (k *Keepalive) Unlock() {
+	k.last = time.Now()
+	k.mu.Unlock()
+}
+
+func (k *Keepalive) idle() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return time.Since(k.last)
+}
+
+// Start calls ping when no request has been sent for the interval, until ctx is done or Stop is called.
+// Errors returned by ping are passed to onError. A non-positive interval is reported to onError and nothing is started.
+func (k *Keepalive) Start(ctx context.Context, interval time.Duration, ping func(ctx context.Context) error, onError func(error)) {
+	k.Stop()
+	if interval <= 0 {
+		if onError != nil {
+			onError(fmt.Errorf("keepalive: invalid interval %v", interval))
+		}
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	k.cmu.Lock()
+	k.cancel = cancel
+	k.cmu.Unlock()
+
+	go func() {
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			wait := interval - k.idle()
+			if wait <= 0 {
+				if err := ping(ctx); err != nil && ctx.Err() == nil && onError != nil {
+					onError(err)
+				}
+				wait = interval
+			}
+			timer.Reset(wait)
+		}
+	}()
+}
+
+// Stop stops the keepalive started by Start.
+func (k *Keepalive) Stop() {
+	k.cmu.Lock()
+	defer k.cmu.Unlock()
+	if k.cancel != nil {
+		k.cancel()
+		k.cancel = nil
+	}
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/binzume/gobanking/common"
+)
+
+// LoggerKey is the option key for a common.Logger of the account.
+// If not specified, logs are written to Logger (debug logs only if Debug is true).
+const LoggerKey = "logger"
+
+const masked = "***"
+
+// names of parameters to be masked.
+const sensitiveNames = `pass|pwd|pin|token|secret|auth|cookie|grid|challenge|answer|word|account|userid|user_id|custno|viewstate|postkey`
+
+var sensitiveKeyRe = regexp.MustCompile(`(?i)` + sensitiveNames)
+var sensitiveFormRe = regexp.MustCompile(`(?i)((?:^|[?&\s])[^=&\s]*(?:` + sensitiveNames + `)[^=&\s]*=)[^&\s]*`)
+var sensitiveJSONRe = regexp.MustCompile(`(?i)("[^"]*(?:` + sensitiveNames + `)[^"]*"\s*:\s*)("(?:[^"\\]|\\.)*"|[-0-9.]+|\[[^\]]*\])`)
+var sensitiveInputRe = regexp.MustCompile(`(?i)(<input\s[^>]*name=["'][^"']*(?:` + sensitiveNames + `)[^"']*["'][^>]*value=["'])[^"']*`)
+var sensitiveInputRe2 = regexp.MustCompile(`(?i)(<input\s[^>]*value=["'])[^"']*(["'][^>]*name=["'][^"']*(?:` + sensitiveNames + `)[^"']*["'])`)
+var sensitiveMapRe = regexp.MustCompile(`(?i)((?:^|[\s\[{,])[\w.:-]*(?:` + sensitiveNames + `)[\w.:-]*:)[^\s\]},]*`)
+
+// Log is a leveled logger of an account. Credentials, tokens and known secrets
+// (ids, account numbers, ...) are masked. A nil *Log discards logs.
+type Log struct {
+	logger common.Logger
+	fields []interface{}
+
+	mu      sync.Mutex
+	secrets []string
+}
+
+// NewLog returns a Log for the bank which writes to options[LoggerKey].
+func NewLog(options map[string]interface{}, bank string) *Log {
+	logger, _ := options[LoggerKey].(common.Logger)
+	if logger == nil {
+		logger = common.LoggerFunc(defaultLog)
+	}
+	return &Log{logger: logger, fields: []interface{}{"bank", bank}}
+}
+
+func defaultLog(level common.LogLevel, msg string, kv ...interface{}) {
+	if Logger == nil || (level == common.LogDebug && !Debug) {
+		return
+	}
+	Logger.Print(FormatLog(level, msg, kv...))
+}
+
+// NewStdLogger returns a common.Logger which writes logs at least min level to l.
+func NewStdLogger(l *log.Logger, min common.LogLevel) common.Logger {
+	return common.LoggerFunc(func(level common.LogLevel, msg string, kv ...interface{}) {
+		if level >= min {
+			l.Print(FormatLog(level, msg, kv...))
+		}
+	})
+}
+
+// FormatLog formats a log like "INFO message key=value ...".
+func FormatLog(level common.LogLevel, msg string, kv ...interface{}) string {
+	var sb strings.Builder
+	sb.WriteString(level.String())
+	sb.WriteString(" ")
+	sb.WriteString(msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&sb, " %v=%q", kv[i], fmt.Sprint(kv[i+1]))
+	}
+	return sb.String()
+}
+
+// AddSecret registers strings to be masked. e.g. login id, account numbers.
+func (l *Log) AddSecret(secrets ...string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range secrets {
+		if len(s) >= 3 {
+			l.secrets = append(l.secrets, s)
+		}
+	}
+}
+
+// Redact masks credentials in s.
+func (l *Log) Redact(s string) string {
+	s = sensitiveFormRe.ReplaceAllString(s, "${1}"+masked)
+	s = sensitiveJSONRe.ReplaceAllString(s, `${1}"`+masked+`"`)
+	s = sensitiveMapRe.ReplaceAllString(s, "${1}"+masked)
+	s = sensitiveInputRe.ReplaceAllString(s, "${1}"+masked)
+	s = sensitiveInputRe2.ReplaceAllString(s, "${1}"+masked+"${2}")
+	if l != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, secret := range l.secrets {
+			s = strings.Replace(s, secret, masked, -1)
+		}
+	}
+	return s
+}
+
+func (l *Log) log(level common.LogLevel, msg string, kv []interface{}) {
+	if l == nil || l.logger == nil {
+		return
+	}
+	fields := append([]interface{}{}, l.fields...)
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		var v interface{} = masked
+		if i+1 < len(kv) && !sensitiveKeyRe.MatchString(key) {
+			switch x := kv[i+1].(type) {
+			case error:
+				v = l.Redact(x.Error())
+			case fmt.Stringer:
+				v = l.Redact(x.String())
+			case string:
+				v = l.Redact(x)
+			case bool, int, int64, float64:
+				v = x
+			default:
+				v = l.Redact(fmt.Sprint(x))
+			}
+		}
+		fields = append(fields, key, v)
+	}
+	l.logger.Log(level, l.Redact(msg), fields...)
+}
+
+func (l *Log) Debug(msg string, kv ...interface{}) { l.log(common.LogDebug, msg, kv) }
+func (l *Log) Info(msg string, kv ...interface{})  { l.log(common.LogInfo, msg, kv) }
+func (l *Log) Warn(msg string, kv ...interface{})  { l.log(common.LogWarn, msg, kv) }
+func (l *Log) Error(msg string, kv ...interface{}) { l.log(common.LogError, msg, kv) }
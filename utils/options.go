@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/binzume/gobanking/common"
+)
+
+// Option keys handled by this library. Other string options are secret words (aikotoba).
+var optionKeys = map[string]bool{
+	"relogin":         true,
+	ClientOptionsKey:  true,
+	LoggerKey:         true,
+	BaseURLKey:        true,
+	DiagnosticsDirKey: true,
+	StrictKey:         true,
+	"base_url_ms":     true, // rakuten
+}
+
+// BaseURLKey is the option key to override the endpoint of a bank. e.g. a fake server, a recording proxy or a new domain.
+const BaseURLKey = "base_url"
+
+// OptionURL returns options[key] ending with "/", or def if not specified.
+func OptionURL(options map[string]interface{}, key, def string) string {
+	u, _ := options[key].(string)
+	if u == "" {
+		return def
+	}
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	return u
+}
+
+// SecretWords returns question-answer pairs in options.
+func SecretWords(options map[string]interface{}) map[string]string {
+	qa := map[string]string{}
+	for k, v := range options {
+		if s, ok := v.(string); ok && !optionKeys[k] {
+			qa[k] = s
+		}
+	}
+	return qa
+}
+
+// LoginGuard refuses logins after repeated failures to avoid account lockout. e.g. *banking.LoginBreaker
+type LoginGuard interface {
+	Allow(bank, id string) error
+	Record(bank, id string, loginErr error) error
+}
+
+var (
+	guardMu    sync.RWMutex
+	loginGuard LoginGuard
+)
+
+// SetLoginGuard sets the LoginGuard used by GuardedLogin. nil disables it.
+func SetLoginGuard(g LoginGuard) {
+	guardMu.Lock()
+	defer guardMu.Unlock()
+	loginGuard = g
+}
+
+// GuardedLogin calls login if the LoginGuard allows, and records the result.
+func GuardedLogin(bank, id string, login func() error) error {
+	guardMu.RLock()
+	g := loginGuard
+	guardMu.RUnlock()
+	if g == nil {
+		return login()
+	}
+	if err := g.Allow(bank, id); err != nil {
+		return err
+	}
+	err := login()
+	if rerr := g.Record(bank, id, err); rerr != nil && Logger != nil {
+		Logger.Println("failed to save login failures:", rerr)
+	}
+	return err
+}
+
+// Relogin holds credentials to login again after the session expired.
+type Relogin struct {
+	bank     string
+	id       string
+	password string
+	options  map[string]interface{}
+	log      *Log
+}
+
+// NewRelogin returns a Relogin if options["relogin"] is true. Otherwise returns nil.
+func NewRelogin(bank, id, password string, options map[string]interface{}, log *Log) *Relogin {
+	if enabled, _ := options["relogin"].(bool); !enabled {
+		return nil
+	}
+	return &Relogin{bank: bank, id: id, password: password, options: options, log: log}
+}
+
+// reloginKey marks the context of a login started by Retry.
+type reloginKey struct{}
+
+// Retry calls fn. If it fails with common.ErrSessionExpired, Retry logs in again and retries fn once.
+// Retry never logs in while a login started by Retry is running (e.g. fn called from Login),
+// and logins are checked by the LoginGuard. Use only for idempotent reads. r may be nil (never retries).
+func (r *Relogin) Retry(ctx context.Context, acc common.Account, fn func() error) error {
+	err := fn()
+	if r == nil || !errors.Is(err, common.ErrSessionExpired) || ctx.Value(reloginKey{}) != nil {
+		return err
+	}
+	r.log.Info("session expired. login again", "error", err)
+	loginCtx := context.WithValue(ctx, reloginKey{}, true)
+	if err := GuardedLogin(r.bank, r.id, func() error { return acc.Login(loginCtx, r.id, r.password, r.options) }); err != nil {
+		return err
+	}
+	return fn()
+}
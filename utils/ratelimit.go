@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Burst requests at once and then one request per Interval.
+// The zero value means no limit.
+type RateLimit struct {
+	Interval time.Duration
+	Burst    int
+}
+
+type limiter struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+var limiters = struct {
+	sync.Mutex
+	m map[string]*limiter
+}{m: map[string]*limiter{}}
+
+// SetRateLimit sets the limit for requests to the domain and its subdomains.
+// The limit is shared by all clients created by NewHttpClient in the process.
+// Bank packages set their defaults in init().
+func SetRateLimit(domain string, limit RateLimit) {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	limiters.Lock()
+	defer limiters.Unlock()
+	limiters.m[strings.ToLower(domain)] = &limiter{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+}
+
+func findLimiter(host string) *limiter {
+	limiters.Lock()
+	defer limiters.Unlock()
+	host = strings.ToLower(host)
+	for {
+		if l, ok := limiters.m[host]; ok {
+			return l
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return nil
+		}
+		host = host[i+1:]
+	}
+}
+
+// waitRateLimit blocks until a request to the host is allowed.
+func waitRateLimit(ctx context.Context, host string) error {
+	l := findLimiter(host)
+	if l == nil {
+		return nil
+	}
+	d := l.reserve(time.Now())
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// reserve takes a token and returns the duration to wait for it.
+func (l *limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit.Interval <= 0 {
+		return 0
+	}
+	l.tokens += float64(now.Sub(l.last)) / float64(l.limit.Interval)
+	if l.tokens > float64(l.limit.Burst) {
+		l.tokens = float64(l.limit.Burst)
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens * float64(l.limit.Interval))
+}
+
+// cancel returns the token taken by reserve to the limiter.
+func (l *limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+}
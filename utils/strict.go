@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"github.com/binzume/gobanking/common"
+)
+
+// StrictKey is the option key for strict parsing (default: true).
+// In strict mode, missing or invalid fields are returned as *common.LayoutError.
+// Otherwise they are logged and ignored (e.g. balance is 0, transactions with unreadable amounts are kept as 0).
+// Login functions without context are lenient unless specified for compatibility.
+const StrictKey = "strict"
+
+// Lenient returns a copy of options which disables strict parsing if not specified.
+func Lenient(options map[string]interface{}) map[string]interface{} {
+	if _, ok := options[StrictKey]; ok {
+		return options
+	}
+	opts := map[string]interface{}{StrictKey: false}
+	for k, v := range options {
+		opts[k] = v
+	}
+	return opts
+}
+
+// Checker checks fields parsed from pages of an account. A nil *Checker is lenient and logs nothing.
+type Checker struct {
+	bank   string
+	strict bool
+	log    *Log
+	diag   *Diagnostics
+}
+
+func NewChecker(options map[string]interface{}, bank string, log *Log, diag *Diagnostics) *Checker {
+	strict, ok := options[StrictKey].(bool)
+	return &Checker{bank: bank, strict: strict || !ok, log: log, diag: diag}
+}
+
+// Page returns a PageCheck for the page. doc is saved to the diagnostics directory if a field is wrong.
+func (c *Checker) Page(page, doc string) *PageCheck {
+	return &PageCheck{c: c, page: page, doc: doc}
+}
+
+// PageCheck collects wrong fields in a page.
+type PageCheck struct {
+	c     *Checker
+	page  string
+	doc   string
+	errs  []*common.LayoutError
+	fatal *common.LayoutError
+}
+
+// Missing records that the field was not found.
+func (p *PageCheck) Missing(field string) {
+	p.Invalid(field, "")
+}
+
+// Invalid records that the value of the field can't be parsed. value "" means missing.
+func (p *PageCheck) Invalid(field, value string) {
+	bank := ""
+	if p.c != nil {
+		bank = p.c.bank
+	}
+	p.errs = append(p.errs, &common.LayoutError{Bank: bank, Page: p.page, Field: field, Value: value})
+}
+
+// Fatal records a wrong field which is an error even in lenient mode. e.g. a transfer amount different from the request.
+func (p *PageCheck) Fatal(field, value string) {
+	p.Invalid(field, value)
+	if p.fatal == nil {
+		p.fatal = p.errs[len(p.errs)-1]
+	}
+}
+
+// Err returns the first wrong field in strict mode. In lenient mode, logs wrong fields and returns nil
+// unless a field is recorded by Fatal.
+func (p *PageCheck) Err() error {
+	if p.c == nil {
+		if p.fatal != nil {
+			return p.fatal
+		}
+		return nil
+	}
+	if len(p.errs) == 0 {
+		return nil
+	}
+	err := p.c.diag.Snapshot(p.errs[0], p.page, p.doc)
+	if p.c.strict {
+		return err
+	}
+	if p.fatal != nil {
+		return p.c.diag.Snapshot(p.fatal, p.page, p.doc)
+	}
+	for _, e := range p.errs {
+		p.c.log.Warn("ignored a wrong field", "page", p.page, "field", e.Field, "value", e.Value, "snapshot", p.errs[0].Snapshot)
+	}
+	return nil
+}
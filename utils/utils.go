@@ -2,14 +2,22 @@ package utils
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"html"
 	"log"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"net/http"
 	"net/http/cookiejar"
+	"net/url"
+
+	"github.com/binzume/gobanking/common"
 
 	"golang.org/x/text/encoding/japanese"
 	"golang.org/x/text/transform"
@@ -44,28 +52,180 @@ func ToSJIS(s string) string {
 }
 
 func NewHttpClient() (*http.Client, error) {
+	return NewHttpClientWithOptions(nil)
+}
+
+// NewHttpClientWithOptions returns a client configured by opts. opts may be nil.
+func NewHttpClientWithOptions(opts *ClientOptions) (*http.Client, error) {
+	return newHttpClient(opts, NewLog(nil, ""))
+}
+
+func newHttpClient(opts *ClientOptions, log *Log) (*http.Client, error) {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+	transport, err := opts.transport()
+	if err != nil {
+		return nil, err
+	}
+	ua := opts.UserAgent
+	if ua == "" {
+		ua = UserAgent
+	}
+	jar, err := NewCookieJar()
+	return &http.Client{Jar: jar, Transport: &agentSetter{transport: transport, userAgent: ua, log: log}, Timeout: opts.Timeout}, err
+}
+
+// NewHttpClientFromOptions returns a client configured by options[ClientOptionsKey]. Requests are logged to log.
+func NewHttpClientFromOptions(options map[string]interface{}, log *Log) (*http.Client, error) {
+	opts, err := ParseClientOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return newHttpClient(opts, log)
+}
+
+// CookieJar is a cookiejar.Jar which can export cookies for common.Session.
+type CookieJar struct {
+	*cookiejar.Jar
+	mu    sync.Mutex
+	saved []*savedCookie
+}
+
+type savedCookie struct {
+	key string // domain;path;name
+	*common.SessionCookie
+}
+
+func NewCookieJar() (*CookieJar, error) {
 	jar, err := cookiejar.New(nil)
-	return &http.Client{Jar: jar, Transport: &agentSetter{}}, err
+	return &CookieJar{Jar: jar}, err
 }
 
-type agentSetter struct{}
+// SetCookies sets cookies to the jar. Cookies with the same name, domain and path are replaced,
+// and deleted or expired cookies are removed.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	base := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+	now := time.Now()
+	for _, c := range cookies {
+		key := cookieKey(u, c)
+		saved := j.saved[:0]
+		for _, s := range j.saved {
+			if s.key != key && !expired(s.Cookie, now) {
+				saved = append(saved, s)
+			}
+		}
+		j.saved = saved
+		if !expired(c, now) {
+			j.saved = append(j.saved, &savedCookie{key: key, SessionCookie: &common.SessionCookie{URL: base.String(), Cookie: c}})
+		}
+	}
+}
 
-func (t *agentSetter) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Set("User-Agent", UserAgent)
-	if Logger != nil {
-		DebugLog("REQUEST", req.Method, req.URL)
+func cookieKey(u *url.URL, c *http.Cookie) string {
+	domain := strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+	if domain == "" {
+		domain = u.Hostname()
+	}
+	p := c.Path
+	if !strings.HasPrefix(p, "/") {
+		// default-path (RFC 6265 5.1.4)
+		p = "/"
+		if i := strings.LastIndex(u.Path, "/"); i > 0 {
+			p = u.Path[:i]
+		}
 	}
-	return http.DefaultTransport.RoundTrip(req)
+	return domain + ";" + p + ";" + c.Name
 }
 
-type TransferStateMap map[string]interface{}
+func expired(c *http.Cookie, now time.Time) bool {
+	return c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now))
+}
 
-func (tr TransferStateMap) Amount() int64 {
-	return tr["amount"].(int64)
+// Export returns cookies set to the jar. Overwritten, deleted or expired cookies are omitted.
+func (j *CookieJar) Export() []*common.SessionCookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	result := []*common.SessionCookie{}
+	now := time.Now()
+	for _, c := range j.saved {
+		if !expired(c.Cookie, now) {
+			result = append(result, c.SessionCookie)
+		}
+	}
+	return result
 }
-func (tr TransferStateMap) Fee() int {
-	return tr["fee"].(int)
+
+func (j *CookieJar) Import(cookies []*common.SessionCookie) error {
+	for _, c := range cookies {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return err
+		}
+		j.SetCookies(u, []*http.Cookie{c.Cookie})
+	}
+	return nil
+}
+
+// ExportCookies returns cookies in the client's jar created by NewHttpClient().
+func ExportCookies(client *http.Client) []*common.SessionCookie {
+	if jar, ok := client.Jar.(*CookieJar); ok {
+		return jar.Export()
+	}
+	return nil
+}
+
+func ImportCookies(client *http.Client, cookies []*common.SessionCookie) error {
+	if jar, ok := client.Jar.(*CookieJar); ok {
+		return jar.Import(cookies)
+	}
+	return errors.New("unsupported cookie jar")
+}
+
+type agentSetter struct {
+	transport http.RoundTripper
+	userAgent string
+	log       *Log
+}
+
+func (t *agentSetter) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", t.userAgent)
+	if err := waitRateLimit(req.Context(), req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	t.log.Debug("request", "method", req.Method, "url", req.URL.String())
+	return t.transport.RoundTrip(req)
 }
-func (tr TransferStateMap) FeeMessage() string {
-	return tr["fee_msg"].(string)
+
+var dateRe = regexp.MustCompile(`(\d{4})\D{1,2}(\d{1,2})\D{1,2}(\d{1,2})`)
+var compactDateRe = regexp.MustCompile(`^(\d{4})(\d{2})(\d{2})`)
+
+// ParseDate parses dates like "2006.01.02", "2006/1/2(月)", "2006年1月2日" or "20060102".
+func ParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	m := dateRe.FindStringSubmatch(s)
+	if m == nil {
+		m = compactDateRe.FindStringSubmatch(s)
+	}
+	if m == nil {
+		return time.Time{}, fmt.Errorf("invalid date: %q", s)
+	}
+	y, _ := strconv.Atoi(m[1])
+	mo, _ := strconv.Atoi(m[2])
+	d, _ := strconv.Atoi(m[3])
+	return time.Date(y, time.Month(mo), d, 0, 0, 0, 0, time.UTC), nil
+}
+
+var amountRe = regexp.MustCompile(`-?[\d,]+`)
+
+// ParseAmount parses the first number in s. e.g. "1,234円" -> 1234
+func ParseAmount(s string) (int64, error) {
+	m := amountRe.FindString(s)
+	if m == "" {
+		return 0, fmt.Errorf("invalid amount: %q", s)
+	}
+	return strconv.ParseInt(strings.Replace(m, ",", "", -1), 10, 64)
 }
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCookieJarExport(t *testing.T) {
+	jar, _ := NewCookieJar()
+	u, _ := url.Parse("https://bank.example.com/app/login.do")
+	jar.SetCookies(u, []*http.Cookie{{Name: "SESSION", Value: "1"}, {Name: "old", Value: "x"}, {Name: "lang", Value: "ja", Path: "/"}})
+	for i := 2; i <= 10; i++ {
+		jar.SetCookies(u, []*http.Cookie{{Name: "SESSION", Value: strconv.Itoa(i)}})
+	}
+	jar.SetCookies(u, []*http.Cookie{{Name: "old", MaxAge: -1}, {Name: "expired", Value: "x", Expires: time.Now().Add(-time.Hour)}})
+
+	cookies := jar.Export()
+	if len(cookies) != 2 {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+	for _, c := range cookies {
+		if c.Cookie.Name == "SESSION" && c.Cookie.Value != "10" {
+			t.Errorf("stale cookie: %v", c.Cookie)
+		}
+	}
+
+	imported, _ := NewCookieJar()
+	if err := imported.Import(cookies); err != nil {
+		t.Fatal(err)
+	}
+	if c := imported.Cookies(u); len(c) != 2 {
+		t.Errorf("unexpected cookies: %v", c)
+	}
+}
+
+func TestKeepaliveInvalidInterval(t *testing.T) {
+	var k Keepalive
+	var pings int
+	var err error
+	k.Start(context.Background(), 0, func(ctx context.Context) error { pings++; return nil }, func(e error) { err = e })
+	time.Sleep(10 * time.Millisecond)
+	k.Stop()
+	if pings != 0 || err == nil {
+		t.Errorf("non-positive interval should be rejected: %d pings, %v", pings, err)
+	}
+}
+
+func TestRateLimitCancel(t *testing.T) {
+	SetRateLimit("cancel.test", RateLimit{Interval: time.Hour, Burst: 1})
+	if err := waitRateLimit(context.Background(), "cancel.test"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := waitRateLimit(ctx, "cancel.test"); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded: %v", err)
+	}
+	// the cancelled request doesn't delay the next one.
+	if d := findLimiter("cancel.test").reserve(time.Now()); d > time.Hour+time.Minute {
+		t.Errorf("token is not returned: %v", d)
+	}
+}